@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
 	"runtime"
@@ -13,8 +14,16 @@ func main() {
 	// debug.SetGCPercent(100)
 	// debug.SetMemoryLimit(2 831 155 200)
 
+	if len(os.Args) > 1 { // CLI mode, see internal/cli.go
+		os.Exit(app.NewApp().Command(os.Args[1:]))
+	}
+
 	myApp := app.NewApp()
-	myAppStop := myApp.Start()
+	myAppStop, err := myApp.Start()
+	if err != nil { // exits whatever the log level, e.g. on a db newer than the binary
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
 
 	defer func() {
 		if err := recover(); err != nil {
@@ -30,7 +39,10 @@ func main() {
 		case syscall.SIGHUP: // kill -SIGHUP <pid> // restarting all for sake of config reload
 			myAppStop(nil)
 			myApp = app.NewApp()
-			myAppStop = myApp.Start()
+			if myAppStop, err = myApp.Start(); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
 		default:
 			myAppStop(nil)
 			os.Exit(0)
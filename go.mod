@@ -3,3 +3,8 @@ module shortlink2
 go 1.21
 
 require github.com/mattn/go-sqlite3 v1.14.22
+
+require (
+	golang.org/x/net v0.24.0
+	golang.org/x/text v0.14.0 // indirect
+)
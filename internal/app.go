@@ -6,8 +6,11 @@ import (
 	"path/filepath"
 	C "shortlink2/internal/cfg"
 	D "shortlink2/internal/db"
+	G "shortlink2/internal/hashgen"
 	H "shortlink2/internal/http"
 	L "shortlink2/internal/log"
+	M "shortlink2/internal/metrics"
+	P "shortlink2/internal/policy"
 	S "shortlink2/internal/service"
 	T "shortlink2/internal/types"
 	//	"time"
@@ -15,7 +18,13 @@ import (
 
 type App struct {
 	hsrv T.IHTTPServer
+	svc  T.ISvcShortLink2
+	auth T.ISvcAuth
+	bulk T.ISvcBulk
+	bak  T.IDBBackup // nil if the db has no snapshots
+	pol  T.IPolicy
 	db   T.IDB
+	mig  T.IDBMigrator // nil if the db has no versioned schema
 	log  T.ILog
 	file string
 }
@@ -23,26 +32,56 @@ type App struct {
 func NewApp() *App {
 	dir, file := execPathAndFname()
 	cfg := C.NewCfgEnvMap(dir, file).Parse()
-	log := L.NewLogFprintf(cfg, 0 /*2*time.Second*/, 0)
-	db := D.NewDBsqlite(cfg, log, dir)
-	// db := D.NewDBmock(cfg, log)
-	svcsl2 := S.NewSvcShortLink2(db, log)
-	hsrv := H.NewHTTPServerNet(svcsl2, log, cfg)
+	log := L.NewLogFprintf(cfg, 0)
+	if err := cfg.Validate(); err != nil {
+		log.LogError(fmt.Errorf("%s: %w", "NewApp(): bad config values, defaults are used instead", err))
+	}
+	reg := M.NewRegistry()
+	reg.Runtime()
+	sqlite := D.NewDBsqlite(cfg, log, dir)
+	db, mig, bak := D.NewDBMetrics(sqlite, reg), T.IDBMigrator(sqlite), T.IDBBackup(sqlite)
+	// db, mig, bak := D.NewDBMetrics(D.NewDBmock(cfg, log), reg), T.IDBMigrator(nil), T.IDBBackup(nil)
+	gen := G.NewHashGen(cfg, log, db)
+	pol := P.NewPolicyFile(cfg, log)
+	svcsl2 := S.NewSvcShortLink2(db, gen, pol, log, cfg)
+	auth := S.NewSvcAuth(db, log, cfg)
+	bulk := S.NewSvcBulk(db, log)
+	hsrv := H.NewHTTPServerNet(svcsl2, auth, bulk, bak, log, cfg, reg)
 	return &App{
 		hsrv: hsrv,
+		svc:  svcsl2,
+		auth: auth,
+		bulk: bulk,
+		bak:  bak,
+		pol:  pol,
 		db:   db,
+		mig:  mig,
 		log:  log,
 		file: file,
 	}
 }
 
-func (a *App) Start() func(err error) {
+// Start gives the error of a db the app can not run on, the caller must exit then
+func (a *App) Start() (func(err error), error) {
 	logStop := a.log.Start()
-	dbShutdown := a.db.ConnectDB()
+	dbShutdown, err1 := a.db.ConnectDB()
+	if err1 != nil {
+		logStop()
+		return nil, fmt.Errorf("%s: %w", a.file+" app not started", err1)
+	}
+	bakStop := func() {}
+	if a.bak != nil {
+		bakStop = a.bak.Start()
+	}
+	polStop := a.pol.Start()
+	svcStop := a.svc.Start()
 	hsrvShutdown := a.hsrv.Run()
 	a.log.LogInfo(a.file + " app started")
 	return func(err error) {
 		hsrvShutdown(err)
+		svcStop()
+		polStop()
+		bakStop()
 		dbShutdown(err)
 		if err != nil {
 			a.log.LogPanic(fmt.Errorf("%s: %w", a.file+" app stoped with error", err))
@@ -50,7 +89,7 @@ func (a *App) Start() func(err error) {
 			a.log.LogInfo(a.file + " app stoped")
 		}
 		logStop()
-	}
+	}, nil
 }
 
 func execPathAndFname() (string, string) {
@@ -17,22 +17,64 @@ var _ T.ICfg = (*CfgEnvMap)(nil)
 type CfgEnvMap struct {
 	vals  map[string]string
 	fname string
+	dir   string // relative paths are taken from here, the executable dir
 }
 
+// pathKeys are files and dirs, relative values are resolved against the executable dir like the default db path
+var pathKeys = []string{T.SL_DB_PATH, T.SL_DB_FIXTURES, T.SL_BACKUP_DIR, T.SL_POLICY_FILE}
+
 func NewCfgEnvMap(dir, file string) *CfgEnvMap {
-	vals := make(map[string]string, 4)
+	vals := make(map[string]string, 48)
 	vals[T.SL_APP_NAME] = file
 	vals[T.SL_LOG_LEVEL] = "INFO" // LOG levels: TRACE, DEBUG, INFO, WARN, ERROR, PANIC, FATAL, NOLOG(default if empty or mess)
 	vals[T.SL_HTTP_IP] = "localhost"
 	vals[T.SL_HTTP_PORT] = ":8080"
+	vals[T.SL_HTTP_DRAIN] = "5s"  // readiness fails this long before shutdown, so load balancers stop sending requests
+	vals[T.SL_HASH_GEN] = "crc32" // hash generators: crc32, counter, random, hashids
+	vals[T.SL_HASH_LEN] = "6"
+	vals[T.SL_HASH_ALPHABET] = "" // generator default if empty
+	vals[T.SL_HASH_SALT] = ""
+	vals[T.SL_ALIAS_CHARSET] = "0123456789abcdefghijklmnopqrstuvwxyz-_"
+	vals[T.SL_ALIAS_MINLEN] = "4"
+	vals[T.SL_ALIAS_MAXLEN] = "32"
+	vals[T.SL_ALIAS_RESERVED] = ""   // comma separated, added to built-in reserved words
+	vals[T.SL_REAP_PERIOD] = "1m"    // expired links purge period, 0 disables
+	vals[T.SL_STATS_BUFFER] = "4096" // click events waiting for flush, new clicks are dropped if full
+	vals[T.SL_STATS_BATCH] = "256"
+	vals[T.SL_STATS_FLUSH] = "5s"
+	vals[T.SL_DB_TIMEOUT] = "3s"           // deadline of every single db operation
+	vals[T.SL_DB_FIXTURES] = ""            // links file (.json, .jsonl, .csv) seeded at start for dev and tests, relative to the executable dir
+	vals[T.SL_DB_PATH] = ""                // sqlite file with optional ?driver params, db/sqlite.db next to the executable if empty
+	vals[T.SL_DB_JOURNAL] = "WAL"          // journal_mode: WAL, DELETE, TRUNCATE, PERSIST, MEMORY, OFF
+	vals[T.SL_DB_SYNC] = "NORMAL"          // synchronous: NORMAL, FULL, EXTRA, OFF
+	vals[T.SL_DB_BUSY_TIMEOUT] = "5s"      // wait for locks of other connections before SQLITE_BUSY
+	vals[T.SL_DB_CACHE_SIZE] = "-2000"     // cache_size: pages if positive, KiB if negative
+	vals[T.SL_BACKUP_DIR] = ""             // snapshots dir, backup next to the db file if empty
+	vals[T.SL_BACKUP_PERIOD] = "0"         // scheduled snapshots period, 0 disables
+	vals[T.SL_BACKUP_KEEP] = "7"           // snapshots kept, older ones are removed
+	vals[T.SL_LINK_SCHEMES] = "http,https" // comma separated
+	vals[T.SL_LINK_MAXLEN] = "2048"        // bytes of the canonical link
+	vals[T.SL_LINK_FRAGMENT] = "keep"      // #fragment policy: keep, strip
+	vals[T.SL_POLICY_FILE] = ""            // allow/deny rules file, no rules if empty
+	vals[T.SL_POLICY_RELOAD] = "10s"       // rules file change check period, 0 disables
+	vals[T.SL_POLICY_PRIVATE] = "deny"     // private and local hosts: deny, allow
+	vals[T.SL_AUTH_ANONSAVE] = "false"     // true lets anyone save links without API key
+	vals[T.SL_TENANT_PREFIX] = ""          // alias prefixes of tenants, e.g. acme:acme-,beta:b-
+	vals[T.SL_RATE_REDIRECT] = "20"        // redirects per second of a client IP, 0 disables
+	vals[T.SL_RATE_REDIRECT_BURST] = "40"
+	vals[T.SL_RATE_SAVE] = "1" // saves per second of an API key or client IP, 0 disables
+	vals[T.SL_RATE_SAVE_BURST] = "10"
+	vals[T.SL_RATE_TRUSTED] = "" // comma separated proxy IPs and CIDRs whose X-Forwarded-For is honoured
+	vals[T.SL_METRICS] = "admin" // GET /metrics access: admin (admin API key), public, off
 	return &CfgEnvMap{
 		vals:  vals,
 		fname: filepath.Join(dir, file, ".env"),
+		dir:   dir,
 	}
 }
 
 func (c *CfgEnvMap) Parse() T.ICfg {
-	log := L.NewLogFprintf(c, 0, 0)
+	log := L.NewLogFprintf(c, 0)
 	c.parseIpFromInterface(log)
 	if len(c.fname) != 0 {
 		if _, err := os.Stat(c.fname); err == nil {
@@ -40,9 +82,32 @@ func (c *CfgEnvMap) Parse() T.ICfg {
 		}
 	}
 	c.parseOsEnvVars(log)
+	c.resolvePaths()
 	return c
 }
 
+// resolvePaths makes relative paths absolute, so they do not depend on the working dir of the process;
+// the "file:" prefix and ?driver params of SL_DB_PATH are kept, in-memory dbs are left as is
+func (c *CfgEnvMap) resolvePaths() {
+	for _, key := range pathKeys {
+		path, query := c.vals[key], ""
+		if key == T.SL_DB_PATH {
+			path, query, _ = strings.Cut(path, "?")
+			if len(query) != 0 {
+				query = "?" + query
+			}
+		}
+		prefix := ""
+		if strings.HasPrefix(path, "file:") {
+			prefix, path = "file:", strings.TrimPrefix(path, "file:")
+		}
+		if (len(path) == 0) || filepath.IsAbs(path) || strings.HasPrefix(path, ":memory:") || (len(c.dir) == 0) {
+			continue
+		}
+		c.vals[key] = prefix + filepath.Join(c.dir, path) + query
+	}
+}
+
 func (c *CfgEnvMap) GetVal(key string) string {
 	val, _ := c.vals[key]
 	return val
@@ -66,16 +131,23 @@ func (c *CfgEnvMap) parseFileDotEnvVars(log T.ILog) {
 	log.LogDebug("load config from file: %s", c.fname)
 	defer f.Close()
 
-	pattern := regexp.MustCompile("^[0-9A-Za-z_]+=[0-9A-Za-z_:/.]+")
+	// values may hold ?driver=params of SL_DB_PATH; the rest of a line after the value may only be a # comment
+	pattern := regexp.MustCompile("^[0-9A-Za-z_]+=[0-9A-Za-z_:/.,?=&%+~@-]+")
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
-		str := pattern.FindString(scanner.Text())
+		line := scanner.Text()
+		str := pattern.FindString(line)
 		if len(str) > 0 {
-			strarr := strings.Split(str, "=")
-			if _, ok := c.vals[strarr[0]]; ok {
-				c.vals[strarr[0]] = strarr[1]
-				log.LogDebug("CFGFILE %s=%s\n", strarr[0], strarr[1])
+			key, val, _ := strings.Cut(str, "=")
+			if _, ok := c.vals[key]; !ok {
+				continue
+			}
+			if rest := strings.TrimSpace(line[len(str):]); (len(rest) > 0) && !strings.HasPrefix(rest, "#") {
+				log.LogError(fmt.Errorf("%s: %s", "(CfgEnvMap).parseFileDotEnvVars(): unparsable value, the key is ignored", line))
+				continue
 			}
+			c.vals[key] = val
+			log.LogDebug("CFGFILE %s=%s\n", key, val)
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -0,0 +1,91 @@
+package cfg
+
+import (
+	"os"
+	"path/filepath"
+	T "shortlink2/internal/types"
+	"strings"
+	"testing"
+)
+
+// testCfgFile gives the config of the executable dir with the .env file of the app
+func testCfgFile(t *testing.T, env string) *CfgEnvMap {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "app"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app", ".env"), []byte(env), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	c := NewCfgEnvMap(dir, "app")
+	c.Parse()
+	return c
+}
+
+func TestParseDotEnv(t *testing.T) {
+	c := testCfgFile(t, strings.Join([]string{
+		"SL_DB_PATH=/var/lib/sl/sqlite.db?_foreign_keys=1&_txlock=immediate # with params",
+		"SL_DB_JOURNAL=delete",
+		"SL_HASH_SALT=two words",
+		"SL_UNKNOWN=1",
+		"# SL_HASH_LEN=9",
+	}, "\n"))
+	want := map[string]string{
+		T.SL_DB_PATH:    "/var/lib/sl/sqlite.db?_foreign_keys=1&_txlock=immediate",
+		T.SL_DB_JOURNAL: "delete",
+		T.SL_HASH_SALT:  "", // not parsable, the default is kept
+		T.SL_HASH_LEN:   "6",
+	}
+	for key, val := range want {
+		if got := c.GetVal(key); got != val {
+			t.Errorf("%s=%q, want %q", key, got, val)
+		}
+	}
+}
+
+func TestResolvePaths(t *testing.T) {
+	c := NewCfgEnvMap("/opt/sl", "app")
+	c.vals[T.SL_DB_PATH] = "file:data/sqlite.db?_foreign_keys=1"
+	c.vals[T.SL_DB_FIXTURES] = "/etc/sl/links.json"
+	c.vals[T.SL_BACKUP_DIR] = "backup"
+	c.vals[T.SL_POLICY_FILE] = ""
+	c.resolvePaths()
+	want := map[string]string{
+		T.SL_DB_PATH:     "file:/opt/sl/data/sqlite.db?_foreign_keys=1",
+		T.SL_DB_FIXTURES: "/etc/sl/links.json",
+		T.SL_BACKUP_DIR:  "/opt/sl/backup",
+		T.SL_POLICY_FILE: "",
+	}
+	for key, val := range want {
+		if got := c.GetVal(key); got != val {
+			t.Errorf("%s=%q, want %q", key, got, val)
+		}
+	}
+	c.vals[T.SL_DB_PATH] = ":memory:"
+	c.resolvePaths()
+	if got := c.GetVal(T.SL_DB_PATH); got != ":memory:" {
+		t.Errorf("in-memory db path %q", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := NewCfgEnvMap(t.TempDir(), "app")
+	c.vals[T.SL_DB_JOURNAL] = "wal"
+	c.vals[T.SL_DB_SYNC] = "Full"
+	if err := c.Validate(); err != nil {
+		t.Fatalf("defaults and lowercase pragmas: %v", err)
+	}
+	c.vals[T.SL_DB_JOURNAL] = "WALL"
+	c.vals[T.SL_DB_BUSY_TIMEOUT] = "5"
+	c.vals[T.SL_DB_PATH] = "/a.db?_x=%zz"
+	c.vals[T.SL_HASH_LEN] = "40"
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("no error for bad values")
+	}
+	for _, key := range []string{T.SL_DB_JOURNAL, T.SL_DB_BUSY_TIMEOUT, T.SL_DB_PATH, T.SL_HASH_LEN} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error %q does not name %s", err, key)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	T "shortlink2/internal/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checks of values, components fall back to defaults on bad values and log it, the db refuses to
+// start on bad SL_DB_* values; Validate reports them all at once for readiness; keys not listed
+// here are free strings
+var checks = map[string]func(string) error{
+	T.SL_LOG_LEVEL:           oneOf("TRACE", "DEBUG", "INFO", "WARN", "ERROR", "PANIC", "FATAL", "NOLOG"),
+	T.SL_HTTP_PORT:           port,
+	T.SL_HTTP_DRAIN:          duration,
+	T.SL_HASH_GEN:            oneOf("crc32", "counter", "random", "hashids"),
+	T.SL_HASH_LEN:            between(4, 32), // the range of NewHashGen
+	T.SL_ALIAS_MINLEN:        positive,
+	T.SL_ALIAS_MAXLEN:        positive,
+	T.SL_REAP_PERIOD:         duration,
+	T.SL_STATS_BUFFER:        positive,
+	T.SL_STATS_BATCH:         positive,
+	T.SL_STATS_FLUSH:         duration,
+	T.SL_DB_TIMEOUT:          duration,
+	T.SL_DB_PATH:             dbPath,
+	T.SL_DB_JOURNAL:          oneOfFold("WAL", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "OFF"),
+	T.SL_DB_SYNC:             oneOfFold("NORMAL", "FULL", "EXTRA", "OFF"),
+	T.SL_DB_BUSY_TIMEOUT:     duration,
+	T.SL_DB_CACHE_SIZE:       integer,
+	T.SL_BACKUP_PERIOD:       duration,
+	T.SL_BACKUP_KEEP:         positive,
+	T.SL_LINK_MAXLEN:         positive,
+	T.SL_LINK_FRAGMENT:       oneOf("keep", "strip"),
+	T.SL_POLICY_RELOAD:       duration,
+	T.SL_POLICY_PRIVATE:      oneOf("deny", "allow"),
+	T.SL_AUTH_ANONSAVE:       oneOf("true", "false"),
+	T.SL_RATE_REDIRECT:       rate,
+	T.SL_RATE_REDIRECT_BURST: positive,
+	T.SL_RATE_SAVE:           rate,
+	T.SL_RATE_SAVE_BURST:     positive,
+	T.SL_METRICS:             oneOf("admin", "public", "off"),
+}
+
+func oneOf(vals ...string) func(string) error {
+	return func(val string) error {
+		for _, v := range vals {
+			if val == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(vals, ", "))
+	}
+}
+
+// oneOfFold ignores case, like sqlite does for pragma values
+func oneOfFold(vals ...string) func(string) error {
+	return func(val string) error {
+		for _, v := range vals {
+			if strings.EqualFold(val, v) {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s, in any case", strings.Join(vals, ", "))
+	}
+}
+
+func port(val string) error {
+	n, err := strconv.Atoi(strings.TrimPrefix(val, ":"))
+	if !strings.HasPrefix(val, ":") || (err != nil) || (n < 0) || (n > 65535) {
+		return fmt.Errorf("%s", "must be :<port>")
+	}
+	return nil
+}
+
+func duration(val string) error {
+	if d, err := time.ParseDuration(val); (err != nil) || (d < 0) {
+		return fmt.Errorf("%s", "must be a non negative duration like 10s")
+	}
+	return nil
+}
+
+func positive(val string) error {
+	if n, err := strconv.Atoi(val); (err != nil) || (n < 1) {
+		return fmt.Errorf("%s", "must be a positive integer")
+	}
+	return nil
+}
+
+func between(min, max int) func(string) error {
+	return func(val string) error {
+		if n, err := strconv.Atoi(val); (err != nil) || (n < min) || (n > max) {
+			return fmt.Errorf("must be an integer in range %d..%d", min, max)
+		}
+		return nil
+	}
+}
+
+func integer(val string) error {
+	if _, err := strconv.Atoi(val); err != nil {
+		return fmt.Errorf("%s", "must be an integer")
+	}
+	return nil
+}
+
+// dbPath allows empty for the default path, the ?query must be well formed driver params
+func dbPath(val string) error {
+	path, query, _ := strings.Cut(val, "?")
+	if _, err := url.ParseQuery(query); err != nil {
+		return fmt.Errorf("%s: %w", "bad driver params", err)
+	}
+	if strings.HasSuffix(path, "/") {
+		return fmt.Errorf("%s", "must be a file, not a dir")
+	}
+	return nil
+}
+
+func rate(val string) error {
+	if r, err := strconv.ParseFloat(val, 64); (err != nil) || (r < 0) {
+		return fmt.Errorf("%s", "must be a non negative number")
+	}
+	return nil
+}
+
+// Validate checks values of known keys, the error lists every bad one
+func (c *CfgEnvMap) Validate() error {
+	errs := []error{}
+	for key, check := range checks {
+		if err := check(c.vals[key]); err != nil {
+			errs = append(errs, fmt.Errorf("%s=%s: %w", key, c.vals[key], err))
+		}
+	}
+	if minlen, maxlen := c.vals[T.SL_ALIAS_MINLEN], c.vals[T.SL_ALIAS_MAXLEN]; (positive(minlen) == nil) && (positive(maxlen) == nil) {
+		if n, m := atoi(minlen), atoi(maxlen); n > m {
+			errs = append(errs, fmt.Errorf("%s=%s: %s", T.SL_ALIAS_MINLEN, minlen, "must not exceed "+T.SL_ALIAS_MAXLEN))
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() }) // map order is random
+	return errors.Join(errs...)
+}
+
+func atoi(val string) int {
+	n, _ := strconv.Atoi(val)
+	return n
+}
@@ -0,0 +1,281 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	F "shortlink2/internal/linkfile"
+	T "shortlink2/internal/types"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+	CLI commands run against the same config and db as the server, logs go to stderr:
+
+	shortlink2 key create [-admin] [-tenant <tenant>] <name>
+	shortlink2 key revoke <id>
+	shortlink2 key list
+	shortlink2 migrate status
+	shortlink2 migrate up [-dry-run]
+	shortlink2 links export [-format jsonl] [-o links.jsonl]
+	shortlink2 links import [-format jsonl] [-conflict skip] links.jsonl
+	shortlink2 backup create
+	shortlink2 backup list
+	shortlink2 backup restore sqlite-20261018T071000.000Z.db
+*/
+
+const cliUsage = `usage:
+	key create [-admin] [-tenant <tenant>] <name>   make API key, the token is shown once
+	key revoke <id>                                 revoke API key
+	key list                                        list API keys
+	migrate status                                  list schema migrations and their state
+	migrate up [-dry-run]                           apply pending migrations, or only show their SQL
+	links export [-format <f>] [-o <file>]          write all links as json, jsonl or csv, to stdout by default
+	links import [-format <f>] [-conflict <c>] <file|->
+	                                                save links of the file, conflict is skip, overwrite or fail
+	backup create                                   make a db snapshot, safe while the server runs
+	backup list                                     list db snapshots, newest first
+	backup restore <name|file>                      check the snapshot and swap it in, stop the server first`
+
+// Command runs CLI command and returns process exit code
+func (a *App) Command(args []string) int {
+	logStop := a.log.Start()
+	defer logStop()
+	if (len(args) >= 1) && (args[0] == "migrate") && (a.mig != nil) {
+		a.mig.ManualMigrate()
+	}
+	dbShutdown, err1 := a.db.ConnectDB()
+	if err1 != nil {
+		fmt.Fprintln(os.Stderr, "error:", err1)
+		return 1
+	}
+	defer dbShutdown(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var err error
+	switch {
+	case (len(args) >= 2) && (args[0] == "key"):
+		err = a.keyCommand(ctx, args[1:])
+	case (len(args) >= 2) && (args[0] == "migrate"):
+		err = a.migrateCommand(ctx, args[1:])
+	case (len(args) >= 2) && (args[0] == "links"):
+		err = a.linksCommand(args[1:])
+	case (len(args) >= 2) && (args[0] == "backup"):
+		err = a.backupCommand(args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, cliUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+func (a *App) keyCommand(ctx context.Context, args []string) error {
+	switch {
+	case args[0] == "create":
+		flags := flag.NewFlagSet("key create", flag.ContinueOnError)
+		admin := flags.Bool("admin", false, "admin key can change links of any owner and list any tenant")
+		tenant := flags.String("tenant", "", "tenant of the key links")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf("%s\n%s", "key name is required", cliUsage)
+		}
+		token, key, err := a.auth.CreateKey(ctx, flags.Arg(0), *tenant, *admin)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("id: %s\nname: %s\ntenant: %s\nadmin: %t\ntoken: %s\n", key.ID, key.Name, key.Tenant, key.Admin, token)
+		return nil
+	case (args[0] == "revoke") && (len(args) == 2):
+		return a.auth.RevokeKey(ctx, args[1])
+	case (args[0] == "list") && (len(args) == 1):
+		keys, err := a.auth.ListKeys(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tTENANT\tADMIN\tCREATED\tREVOKED")
+		for _, key := range keys {
+			revoked := "-"
+			if !key.Revoked.IsZero() {
+				revoked = key.Revoked.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%s\t%s\n", key.ID, key.Name, key.Tenant, key.Admin, key.Created.Format(time.RFC3339), revoked)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("%s\n%s", "bad key command", cliUsage)
+	}
+}
+
+func (a *App) migrateCommand(ctx context.Context, args []string) error {
+	if a.mig == nil {
+		return fmt.Errorf("%s", "the db has no versioned schema")
+	}
+	switch {
+	case (args[0] == "status") && (len(args) == 1):
+		list, err := a.mig.Migrations(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, m := range list {
+			applied := "pending"
+			if !m.Applied.IsZero() {
+				applied = m.Applied.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", m.Version, m.Name, applied)
+		}
+		return tw.Flush()
+	case args[0] == "up":
+		flags := flag.NewFlagSet("migrate up", flag.ContinueOnError)
+		dryRun := flags.Bool("dry-run", false, "show SQL of pending migrations without applying them")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		pending, err := a.mig.Migrate(ctx, *dryRun)
+		for _, m := range pending {
+			if *dryRun {
+				fmt.Printf("-- pending %d %s\n%s\n", m.Version, m.Name, m.SQL)
+			} else {
+				fmt.Printf("applied %d %s\n", m.Version, m.Name)
+			}
+		}
+		if (err == nil) && (len(pending) == 0) {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	default:
+		return fmt.Errorf("%s\n%s", "bad migrate command", cliUsage)
+	}
+}
+
+// linksCommand has no deadline, big exports and imports take longer than other commands
+func (a *App) linksCommand(args []string) error {
+	ctx := context.Background()
+	exported := func(report T.BulkReport) {
+		fmt.Fprintf(os.Stderr, "links: %d exported\n", report.Read)
+	}
+	imported := func(report T.BulkReport) {
+		fmt.Fprintf(os.Stderr, "links: %d read, %d added, %d overwritten, %d skipped, %d failed\n", report.Read, report.Added, report.Overwritten, report.Skipped, report.Failed)
+	}
+	switch args[0] {
+	case "export":
+		flags := flag.NewFlagSet("links export", flag.ContinueOnError)
+		format := flags.String("format", "", "json, jsonl or csv, by the -o extension or jsonl by default")
+		out := flags.String("o", "", "output file, stdout by default")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 0 {
+			return fmt.Errorf("%s\n%s", "bad links export arguments", cliUsage)
+		}
+		fileFormat, err := linksFormat(*format, *out)
+		if err != nil { // before the file is made, so a bad format does not truncate it
+			return err
+		}
+		w := io.Writer(os.Stdout)
+		if len(*out) != 0 {
+			f, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		_, err = a.bulk.Export(ctx, w, fileFormat, exported)
+		return err
+	case "import":
+		flags := flag.NewFlagSet("links import", flag.ContinueOnError)
+		format := flags.String("format", "", "json, jsonl or csv, by the file extension or jsonl by default")
+		conflict := flags.String("conflict", T.ConflictSkip, "skip, overwrite or fail for hashes already in the db")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf("%s\n%s", "links file is required, - for stdin", cliUsage)
+		}
+		r, fname := io.Reader(os.Stdin), flags.Arg(0)
+		if fname != "-" {
+			f, err := os.Open(fname)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		} else {
+			fname = ""
+		}
+		fileFormat, err := linksFormat(*format, fname)
+		if err != nil {
+			return err
+		}
+		_, err = a.bulk.Import(ctx, r, fileFormat, *conflict, imported)
+		return err
+	default:
+		return fmt.Errorf("%s\n%s", "bad links command", cliUsage)
+	}
+}
+
+// linksFormat is the flag value, or the file extension, or jsonl for stdin and stdout
+func linksFormat(format, fname string) (string, error) {
+	switch {
+	case len(format) != 0:
+		if !slices.Contains(F.Formats, format) {
+			return "", fmt.Errorf("unknown links format %q, use %s", format, strings.Join(F.Formats, ", "))
+		}
+		return format, nil
+	case len(fname) != 0:
+		return F.FormatOf(fname)
+	default:
+		return F.JSONL, nil
+	}
+}
+
+// backupCommand has no deadline, snapshots of a big db take longer than other commands
+func (a *App) backupCommand(args []string) error {
+	if a.bak == nil {
+		return fmt.Errorf("%s", "the db has no snapshots")
+	}
+	ctx := context.Background()
+	switch {
+	case (args[0] == "create") && (len(args) == 1):
+		snap, err := a.bak.Backup(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%d\n", snap.Name, snap.Size)
+		return nil
+	case (args[0] == "list") && (len(args) == 1):
+		snaps, err := a.bak.Snapshots()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSIZE\tCREATED")
+		for _, snap := range snaps {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", snap.Name, snap.Size, snap.Created.Format(time.RFC3339))
+		}
+		return tw.Flush()
+	case (args[0] == "restore") && (len(args) == 2):
+		if err := a.bak.Restore(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Println("restored", args[1])
+		return nil
+	default:
+		return fmt.Errorf("%s\n%s", "bad backup command", cliUsage)
+	}
+}
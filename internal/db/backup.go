@@ -0,0 +1,284 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	T "shortlink2/internal/types"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Snapshots are made by VACUUM INTO, it reads the db in one transaction, so the copy is consistent
+	while the server keeps writing. They are named sqlite-<UTC time>.db in SL_BACKUP_DIR:
+
+	shortlink2 backup create
+	shortlink2 backup list
+	shortlink2 backup restore sqlite-20261018T071000.000Z.db   # with the server stopped
+	curl -X POST localhost:8080/api/v1/backups -H 'Authorization: Bearer sl2_...'
+
+	Restore refuses to swap the db while another process has it open, it takes an exclusive lock
+	first. The check relies on the WAL journal mode, where every open connection holds a lock; with
+	other modes an idle server holds nothing, so make sure it is stopped.
+*/
+
+var _ T.IDBBackup = (*DBsqlite)(nil)
+
+const (
+	snapshotPrefix = "sqlite-"
+	snapshotTime   = "20060102T150405.000Z"
+)
+
+// backupDir is SL_BACKUP_DIR, or backup next to the db file
+func (s *DBsqlite) backupDir() string {
+	if dir := s.cfg.GetVal(T.SL_BACKUP_DIR); len(dir) != 0 {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(s.dbpath), "backup")
+}
+
+func (s *DBsqlite) Backup(ctx context.Context) (T.Snapshot, error) {
+	return s.backup(ctx, true)
+}
+
+// backup prunes old snapshots only if asked, the safety copy of Restore must not remove its source
+func (s *DBsqlite) backup(ctx context.Context, prune bool) (T.Snapshot, error) {
+	s.backupMu.Lock()
+	defer s.backupMu.Unlock()
+	if s.db == nil {
+		return T.Snapshot{}, fmt.Errorf("%w: %s", T.ErrUnavailable, "db is not connected")
+	}
+	dir := s.backupDir()
+	if err1 := os.MkdirAll(dir, 0o750); err1 != nil {
+		return T.Snapshot{}, s.errUnavailable(ctx, "DBsqlite.backup(): unable to make backup dir", err1)
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond) // the name keeps milliseconds
+	name := snapshotPrefix + now.Format(snapshotTime) + ".db"
+	if _, err2 := s.db.ExecContext(ctx, "VACUUM INTO ?", filepath.Join(dir, name)); err2 != nil {
+		return T.Snapshot{}, s.errUnavailable(ctx, "DBsqlite.backup(): unable to VACUUM INTO", err2)
+	}
+	info, err3 := os.Stat(filepath.Join(dir, name))
+	if err3 != nil {
+		return T.Snapshot{}, s.errUnavailable(ctx, "DBsqlite.backup(): snapshot is lost", err3)
+	}
+	s.log.Ctx(ctx).LogInfo("db snapshot %s made, %d bytes", name, info.Size())
+	if prune {
+		s.pruneSnapshots(ctx)
+	}
+	return T.Snapshot{Name: name, Size: info.Size(), Created: now}, nil
+}
+
+func (s *DBsqlite) Snapshots() ([]T.Snapshot, error) {
+	entries, err := os.ReadDir(s.backupDir())
+	if os.IsNotExist(err) {
+		return []T.Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", "DBsqlite.Snapshots(): unable to read backup dir", err)
+	}
+	snaps := []T.Snapshot{}
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(strings.TrimSuffix(entry.Name(), ".db"), snapshotPrefix)
+		created, err := time.Parse(snapshotTime, stamp)
+		if !ok || (err != nil) || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, T.Snapshot{Name: entry.Name(), Size: info.Size(), Created: created})
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Created.After(snaps[j].Created) })
+	return snaps, nil
+}
+
+// pruneSnapshots keeps SL_BACKUP_KEEP newest snapshots, files of other names are never touched
+func (s *DBsqlite) pruneSnapshots(ctx context.Context) {
+	keep, err := strconv.Atoi(s.cfg.GetVal(T.SL_BACKUP_KEEP))
+	if (err != nil) || (keep < 1) {
+		s.log.LogError(fmt.Errorf("%s: %s=%s", "DBsqlite.pruneSnapshots(): bad retention, keeping all snapshots", T.SL_BACKUP_KEEP, s.cfg.GetVal(T.SL_BACKUP_KEEP)))
+		return
+	}
+	snaps, err := s.Snapshots()
+	if err != nil {
+		s.log.Ctx(ctx).LogError(err)
+		return
+	}
+	for i := keep; i < len(snaps); i++ {
+		if err := os.Remove(filepath.Join(s.backupDir(), snaps[i].Name)); err != nil {
+			s.log.Ctx(ctx).LogError(fmt.Errorf("%s: %w", "DBsqlite.pruneSnapshots(): unable to remove old snapshot", err))
+			continue
+		}
+		s.log.Ctx(ctx).LogInfo("db snapshot %s removed by retention", snaps[i].Name)
+	}
+}
+
+// checkSnapshot opens the file read only, runs integrity_check and makes sure the binary knows its schema
+func (s *DBsqlite) checkSnapshot(ctx context.Context, fname string) error {
+	if _, err := os.Stat(fname); err != nil {
+		return err
+	}
+	snap, err := sql.Open("sqlite3", "file:"+fname+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+	rows, err1 := snap.QueryContext(ctx, "PRAGMA integrity_check")
+	if err1 != nil {
+		return fmt.Errorf("%s: %w", "not a sqlite db", err1)
+	}
+	problems := []string{}
+	for rows.Next() {
+		var line string
+		if err2 := rows.Scan(&line); err2 != nil {
+			rows.Close()
+			return err2
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	rows.Close()
+	if len(problems) != 0 {
+		return fmt.Errorf("%s: %s", "integrity check failed", strings.Join(problems, "; "))
+	}
+	probe := &DBsqlite{db: snap, log: s.log}
+	if ok, err3 := probe.tableExists(ctx, "shortlink"); (err3 != nil) || !ok {
+		return fmt.Errorf("%s", "no shortlink table, it is not a shortlink2 db")
+	}
+	_, err4 := probe.Migrations(ctx)
+	return err4
+}
+
+// Restore takes a snapshot name or path, the snapshot is copied next to the db first and the current
+// db is saved as a snapshot without retention, so a wrong restore can be undone; the restored db is
+// migrated if it is older than the binary. It fails if another process has the db open
+func (s *DBsqlite) Restore(ctx context.Context, name string) error {
+	fname := name
+	if !strings.ContainsRune(name, os.PathSeparator) {
+		fname = filepath.Join(s.backupDir(), name)
+	}
+	if err := s.checkSnapshot(ctx, fname); err != nil {
+		return fmt.Errorf("DBsqlite.Restore(): snapshot %s is not valid: %w", fname, err)
+	}
+	tmp := s.dbpath + ".restore"
+	if err := copyFile(fname, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%s: %w", "DBsqlite.Restore(): unable to copy snapshot", err)
+	}
+	defer os.Remove(tmp) // left only if the swap failed
+	if s.db != nil {
+		if _, err := s.backup(ctx, false); err != nil {
+			return fmt.Errorf("%s: %w", "DBsqlite.Restore(): unable to save the current db", err)
+		}
+		s.db.Close()
+		s.db = nil
+	}
+	errSwap := s.checkUnused(ctx)
+	if errSwap == nil {
+		errSwap = s.swapDB(tmp)
+	}
+	db, err := sql.Open("sqlite3", s.dsn) // the old db is reopened if the swap failed
+	if err != nil {
+		return fmt.Errorf("%s: %w", "DBsqlite.Restore(): unable to open restored db", err)
+	}
+	s.db = db
+	if errSwap != nil {
+		return fmt.Errorf("%s: %w", "DBsqlite.Restore(): db file is not swapped", errSwap)
+	}
+	if _, err := s.Migrate(ctx, false); err != nil {
+		return fmt.Errorf("%s: %w", "DBsqlite.Restore(): unable to migrate restored db", err)
+	}
+	s.log.Ctx(ctx).LogInfo("db restored from %s", fname)
+	return nil
+}
+
+// checkUnused takes an exclusive lock without waiting, it fails while a server has the db open
+func (s *DBsqlite) checkUnused(ctx context.Context) error {
+	if _, err := os.Stat(s.dbpath); os.IsNotExist(err) {
+		return nil
+	}
+	lock, err1 := sql.Open("sqlite3", "file:"+s.dbpath+"?_busy_timeout=0&_locking_mode=EXCLUSIVE")
+	if err1 != nil {
+		return err1
+	}
+	defer lock.Close()
+	conn, err2 := lock.Conn(ctx) // the driver runs pragmas on connect, they may fail on the lock too
+	if err2 == nil {
+		defer conn.Close()
+		_, err2 = conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+	}
+	if err2 != nil {
+		return fmt.Errorf("%s: %w", "the db is open by another process, stop the server first", err2)
+	}
+	_, err3 := conn.ExecContext(ctx, "ROLLBACK")
+	return err3
+}
+
+// swapDB renames the copy of the snapshot over the db, so the db file is never half written
+func (s *DBsqlite) swapDB(tmp string) error {
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} { // journals of the old db must not be applied to the new one
+		os.Remove(s.dbpath + suffix)
+	}
+	return os.Rename(tmp, s.dbpath)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Start makes snapshots every SL_BACKUP_PERIOD
+func (s *DBsqlite) Start() func() {
+	var wg sync.WaitGroup
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	period, err := time.ParseDuration(s.cfg.GetVal(T.SL_BACKUP_PERIOD))
+	if (err != nil) || (period < 0) {
+		s.log.LogError(fmt.Errorf("%s: %s=%s", "DBsqlite.Start(): bad backup period, scheduled backups are off", T.SL_BACKUP_PERIOD, s.cfg.GetVal(T.SL_BACKUP_PERIOD)))
+		period = 0
+	}
+	if period != 0 {
+		wg.Add(1)
+		go func() {
+			ticker := time.NewTicker(period)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					s.Backup(ctx) // errors are logged by Backup
+				case <-ctx.Done():
+					wg.Done()
+					return
+				}
+			}
+		}()
+	}
+	return func() {
+		ctxCancel()
+		wg.Wait()
+	}
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	T "shortlink2/internal/types"
+	"testing"
+	"time"
+)
+
+// backupTestDB is a migrated db keeping two snapshots
+func backupTestDB(t *testing.T) *DBsqlite {
+	cfg := testCfg(t)
+	cfg[T.SL_BACKUP_KEEP] = "2"
+	s := openTestDB(t, cfg)
+	if _, err := s.Migrate(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// snapshot makes a backup with a link saved right before it, names differ by milliseconds
+func snapshot(t *testing.T, s *DBsqlite, hash string) T.Snapshot {
+	ctx := context.Background()
+	if err := s.SaveLinkPair(ctx, T.DBMess{Hash: hash, Link: "http://" + hash}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	snap, err := s.Backup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snap
+}
+
+func snapshotNames(t *testing.T, s *DBsqlite) []string {
+	snaps, err := s.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, snap := range snaps {
+		names = append(names, snap.Name)
+	}
+	return names
+}
+
+func TestBackupRetention(t *testing.T) {
+	s := backupTestDB(t)
+	other := filepath.Join(s.backupDir(), "notes.txt")
+	snap1 := snapshot(t, s, "a1")
+	os.WriteFile(other, []byte("not a snapshot"), 0o640)
+	snap2 := snapshot(t, s, "b2")
+	snap3 := snapshot(t, s, "c3")
+	names := snapshotNames(t, s)
+	if (len(names) != 2) || (names[0] != snap3.Name) || (names[1] != snap2.Name) {
+		t.Fatalf("snapshots %v, want the newest %s and %s", names, snap3.Name, snap2.Name)
+	}
+	if _, err := os.Stat(filepath.Join(s.backupDir(), snap1.Name)); !os.IsNotExist(err) {
+		t.Fatalf("the oldest snapshot is kept: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Fatalf("retention removed a file which is not a snapshot: %v", err)
+	}
+}
+
+func TestRestoreOldest(t *testing.T) {
+	ctx := context.Background()
+	s := backupTestDB(t)
+	oldest := snapshot(t, s, "a1")
+	snapshot(t, s, "b2")
+	if err := s.SaveLinkPair(ctx, T.DBMess{Hash: "c3", Link: "http://c3"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if err := s.Restore(ctx, oldest.Name); err != nil {
+		t.Fatalf("restore of the oldest snapshot: %v", err)
+	}
+	if _, err := s.LoadLinkPair(ctx, "a1"); err != nil {
+		t.Fatalf("a1 of the snapshot: %v", err)
+	}
+	for _, hash := range []string{"b2", "c3"} {
+		if _, err := s.LoadLinkPair(ctx, hash); !errors.Is(err, T.ErrNotFound) {
+			t.Fatalf("%s is made after the snapshot: %v", hash, err)
+		}
+	}
+	// the safety copy of the replaced db is kept besides both snapshots, none is pruned
+	if names := snapshotNames(t, s); len(names) != 3 {
+		t.Fatalf("snapshots after restore %v, want 3", names)
+	}
+	if _, err := os.Stat(s.dbpath + ".restore"); !os.IsNotExist(err) {
+		t.Fatalf("restore copy is left: %v", err)
+	}
+}
+
+func TestRestoreRefusedWhileOpen(t *testing.T) {
+	ctx := context.Background()
+	s := backupTestDB(t)
+	snap := snapshot(t, s, "a1")
+	if err := s.SaveLinkPair(ctx, T.DBMess{Hash: "b2", Link: "http://b2"}); err != nil {
+		t.Fatal(err)
+	}
+	server, err := sql.Open("sqlite3", s.dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if err := server.Ping(); err != nil { // an idle connection of a running server
+		t.Fatal(err)
+	}
+	if err := s.Restore(ctx, snap.Name); err == nil {
+		t.Fatal("restore swapped the db open by another process")
+	}
+	if _, err := s.LoadLinkPair(ctx, "b2"); err != nil {
+		t.Fatalf("the current db is lost: %v", err)
+	}
+	server.Close()
+	if err := s.Restore(ctx, snap.Name); err != nil {
+		t.Fatalf("restore after the server is stopped: %v", err)
+	}
+	if _, err := s.LoadLinkPair(ctx, "b2"); !errors.Is(err, T.ErrNotFound) {
+		t.Fatalf("b2 is made after the snapshot: %v", err)
+	}
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	ctx := context.Background()
+	s := backupTestDB(t)
+	snapshot(t, s, "a1")
+	bad := filepath.Join(t.TempDir(), "bad.db")
+	os.WriteFile(bad, []byte("not a db"), 0o640)
+	for _, name := range []string{bad, "sqlite-missing.db"} {
+		if err := s.Restore(ctx, name); err == nil {
+			t.Fatalf("restore of %s", name)
+		}
+	}
+	if _, err := s.LoadLinkPair(ctx, "a1"); err != nil {
+		t.Fatalf("the current db is lost: %v", err)
+	}
+	if names := snapshotNames(t, s); len(names) != 1 {
+		t.Fatalf("snapshots %v, invalid restores must not save the db", names)
+	}
+}
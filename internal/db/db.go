@@ -0,0 +1,82 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	T "shortlink2/internal/types"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// opTimeout reads SL_DB_TIMEOUT, the deadline of every single db operation
+func opTimeout(cfg T.ICfg, log T.ILog) time.Duration {
+	timeout, err := time.ParseDuration(cfg.GetVal(T.SL_DB_TIMEOUT))
+	if (err != nil) || (timeout <= 0) {
+		log.LogError(fmt.Errorf("%s: %s=%s", "opTimeout(): bad db operation timeout, using 3s", T.SL_DB_TIMEOUT, cfg.GetVal(T.SL_DB_TIMEOUT)))
+		return 3 * time.Second
+	}
+	return timeout
+}
+
+var (
+	journalModes = []string{"WAL", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "OFF"}
+	syncModes    = []string{"NORMAL", "FULL", "EXTRA", "OFF"}
+)
+
+// sqliteDSN gives the db file path and the driver DSN with pragmas of SL_DB_* keys, modes are case
+// insensitive; driver params in the ?query of SL_DB_PATH win over the keys:
+//
+//	SL_DB_PATH=/var/lib/shortlink2/sqlite.db?_foreign_keys=1
+//
+// The error lists every bad value of the keys and of the pragma params, the DSN has defaults for
+// them; the db must not start on them as journal and sync modes decide what survives a crash
+func sqliteDSN(cfg T.ICfg, dir string) (string, string, error) {
+	errs := []error{}
+	dbpath, query, _ := strings.Cut(cfg.GetVal(T.SL_DB_PATH), "?")
+	if len(dbpath) == 0 {
+		dbpath = filepath.Join(dir, "db/sqlite.db")
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s=%s: %s: %w", T.SL_DB_PATH, cfg.GetVal(T.SL_DB_PATH), "bad driver params", err))
+		params = url.Values{}
+	}
+	// pragma sets the driver param of the key, conv gives the driver value or false for bad ones;
+	// a param of the ?query is checked by driver, the driver itself fails on it only at the first query
+	pragma := func(param, key, def string, conv, driver func(string) (string, bool)) {
+		val, ok := conv(cfg.GetVal(key))
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s=%s: %s", key, cfg.GetVal(key), "bad sqlite pragma value"))
+			val, _ = conv(def)
+		}
+		if params.Has(param) {
+			if val, ok = driver(params.Get(param)); !ok {
+				errs = append(errs, fmt.Errorf("%s: %s=%s: %s", T.SL_DB_PATH, param, params.Get(param), "bad sqlite pragma value"))
+				val, _ = conv(def)
+			}
+		}
+		params.Set(param, val)
+	}
+	oneOf := func(vals []string) func(string) (string, bool) {
+		return func(val string) (string, bool) {
+			val = strings.ToUpper(val)
+			return val, slices.Contains(vals, val)
+		}
+	}
+	integer := func(val string) (string, bool) {
+		_, err := strconv.Atoi(val)
+		return val, err == nil
+	}
+	pragma("_journal_mode", T.SL_DB_JOURNAL, "WAL", oneOf(journalModes), oneOf(journalModes))
+	pragma("_synchronous", T.SL_DB_SYNC, "NORMAL", oneOf(syncModes), oneOf(syncModes))
+	pragma("_busy_timeout", T.SL_DB_BUSY_TIMEOUT, "5s", func(val string) (string, bool) {
+		timeout, err := time.ParseDuration(val)
+		return strconv.FormatInt(timeout.Milliseconds(), 10), (err == nil) && (timeout >= 0)
+	}, integer)
+	pragma("_cache_size", T.SL_DB_CACHE_SIZE, "-2000", integer, integer)
+	return strings.TrimPrefix(dbpath, "file:"), dbpath + "?" + params.Encode(), errors.Join(errs...)
+}
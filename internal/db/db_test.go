@@ -0,0 +1,80 @@
+package db
+
+import (
+	"net/url"
+	"path/filepath"
+	L "shortlink2/internal/log"
+	T "shortlink2/internal/types"
+	"strings"
+	"testing"
+)
+
+func TestSqliteDSN(t *testing.T) {
+	tests := []struct {
+		name   string
+		vals   cfgMap
+		path   string
+		params map[string]string // driver params of the DSN
+		err    []string          // keys named by the error
+	}{
+		{"defaults", cfgMap{T.SL_DB_JOURNAL: "WAL", T.SL_DB_SYNC: "NORMAL", T.SL_DB_BUSY_TIMEOUT: "5s", T.SL_DB_CACHE_SIZE: "-2000"},
+			filepath.Join("dir", "db/sqlite.db"),
+			map[string]string{"_journal_mode": "WAL", "_synchronous": "NORMAL", "_busy_timeout": "5000", "_cache_size": "-2000"}, nil},
+		{"lowercase modes", cfgMap{T.SL_DB_PATH: "/a.db", T.SL_DB_JOURNAL: "delete", T.SL_DB_SYNC: "Full", T.SL_DB_BUSY_TIMEOUT: "1s", T.SL_DB_CACHE_SIZE: "100"},
+			"/a.db", map[string]string{"_journal_mode": "DELETE", "_synchronous": "FULL", "_busy_timeout": "1000", "_cache_size": "100"}, nil},
+		{"params win", cfgMap{T.SL_DB_PATH: "file:/a.db?_journal_mode=MEMORY&_foreign_keys=1", T.SL_DB_JOURNAL: "WAL", T.SL_DB_SYNC: "NORMAL", T.SL_DB_BUSY_TIMEOUT: "5s", T.SL_DB_CACHE_SIZE: "-2000"},
+			"/a.db", map[string]string{"_journal_mode": "MEMORY", "_foreign_keys": "1", "_synchronous": "NORMAL"}, nil},
+		{"bad values", cfgMap{T.SL_DB_PATH: "/a.db", T.SL_DB_JOURNAL: "WALL", T.SL_DB_SYNC: "NORMAL", T.SL_DB_BUSY_TIMEOUT: "5", T.SL_DB_CACHE_SIZE: "big"},
+			"/a.db", map[string]string{"_journal_mode": "WAL", "_busy_timeout": "5000", "_cache_size": "-2000"},
+			[]string{T.SL_DB_JOURNAL + "=WALL", T.SL_DB_BUSY_TIMEOUT + "=5", T.SL_DB_CACHE_SIZE + "=big"}},
+		{"lowercase param", cfgMap{T.SL_DB_PATH: "/a.db?_synchronous=extra", T.SL_DB_JOURNAL: "WAL", T.SL_DB_SYNC: "NORMAL", T.SL_DB_BUSY_TIMEOUT: "5s", T.SL_DB_CACHE_SIZE: "-2000"},
+			"/a.db", map[string]string{"_synchronous": "EXTRA"}, nil},
+		{"bad pragma params", cfgMap{T.SL_DB_PATH: "/a.db?_journal_mode=bogus&_busy_timeout=5s", T.SL_DB_JOURNAL: "WAL", T.SL_DB_SYNC: "NORMAL", T.SL_DB_BUSY_TIMEOUT: "5s", T.SL_DB_CACHE_SIZE: "-2000"},
+			"/a.db", map[string]string{"_journal_mode": "WAL", "_busy_timeout": "5000"}, []string{"_journal_mode=bogus", "_busy_timeout=5s"}},
+		{"bad params", cfgMap{T.SL_DB_PATH: "/a.db?_x=%zz", T.SL_DB_JOURNAL: "WAL", T.SL_DB_SYNC: "NORMAL", T.SL_DB_BUSY_TIMEOUT: "5s", T.SL_DB_CACHE_SIZE: "-2000"},
+			"/a.db", map[string]string{"_journal_mode": "WAL"}, []string{T.SL_DB_PATH}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, dsn, err := sqliteDSN(tt.vals, "dir")
+			if path != tt.path {
+				t.Fatalf("path %q, want %q", path, tt.path)
+			}
+			_, query, _ := strings.Cut(dsn, "?")
+			params, _ := url.ParseQuery(query)
+			for key, val := range tt.params {
+				if params.Get(key) != val {
+					t.Errorf("%s=%q, want %q in %s", key, params.Get(key), val, dsn)
+				}
+			}
+			if (err != nil) != (len(tt.err) != 0) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			for _, key := range tt.err {
+				if !strings.Contains(err.Error(), key) {
+					t.Errorf("error %q does not name %s", err, key)
+				}
+			}
+		})
+	}
+}
+
+// the log does not stop the process at NOLOG, the error of ConnectDB must do it
+func TestConnectBadPragma(t *testing.T) {
+	for _, bad := range []cfgMap{{T.SL_DB_JOURNAL: "bogus"}, {T.SL_DB_PATH: "?_journal_mode=bogus"}} {
+		cfg := testCfg(t)
+		for key, val := range bad {
+			if key == T.SL_DB_PATH {
+				val = cfg[key] + val
+			}
+			cfg[key] = val
+		}
+		s := NewDBsqlite(cfg, L.NewLogFprintf(cfg, 0), t.TempDir())
+		if _, err := s.ConnectDB(); err == nil {
+			t.Fatalf("ConnectDB() starts on %v", bad)
+		}
+		if s.db != nil {
+			t.Fatalf("ConnectDB() opens the db on %v", bad)
+		}
+	}
+}
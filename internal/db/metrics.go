@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"errors"
+	M "shortlink2/internal/metrics"
+	T "shortlink2/internal/types"
+	"time"
+)
+
+var _ T.IDB = (*DBMetrics)(nil)
+
+// dbBuckets are finer than default ones, single sqlite operations take well under a millisecond
+var dbBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// DBMetrics wraps any IDB and measures latency of its operations, ErrNotFound and ErrConflict
+// are answers, not failures, so they are not counted as errors
+type DBMetrics struct {
+	db      T.IDB
+	latency *M.Histogram
+	errors  *M.Counter
+}
+
+func NewDBMetrics(db T.IDB, reg *M.Registry) *DBMetrics {
+	return &DBMetrics{
+		db:      db,
+		latency: reg.Histogram("shortlink2_db_operation_duration_seconds", "Latency of db operations.", dbBuckets, "op"),
+		errors:  reg.Counter("shortlink2_db_errors_total", "Failed db operations.", "op"),
+	}
+}
+
+func (m *DBMetrics) observe(op string, start time.Time, err error) {
+	m.latency.Observe(time.Since(start).Seconds(), op)
+	if (err != nil) && !errors.Is(err, T.ErrNotFound) && !errors.Is(err, T.ErrConflict) {
+		m.errors.Inc(op)
+	}
+}
+
+func (m *DBMetrics) SaveLinkPair(ctx context.Context, pair T.DBMess) error {
+	start := time.Now()
+	err := m.db.SaveLinkPair(ctx, pair)
+	m.observe("save_link", start, err)
+	return err
+}
+
+func (m *DBMetrics) LoadLinkPair(ctx context.Context, hash string) (T.DBMess, error) {
+	start := time.Now()
+	pair, err := m.db.LoadLinkPair(ctx, hash)
+	m.observe("load_link", start, err)
+	return pair, err
+}
+
+func (m *DBMetrics) UpdateLinkPair(ctx context.Context, hash, link string, expire time.Time) error {
+	start := time.Now()
+	err := m.db.UpdateLinkPair(ctx, hash, link, expire)
+	m.observe("update_link", start, err)
+	return err
+}
+
+func (m *DBMetrics) DeleteLinkPair(ctx context.Context, hash string) error {
+	start := time.Now()
+	err := m.db.DeleteLinkPair(ctx, hash)
+	m.observe("delete_link", start, err)
+	return err
+}
+
+func (m *DBMetrics) ListLinks(ctx context.Context, tenant, owner, after string, limit int) ([]T.DBMess, error) {
+	start := time.Now()
+	pairs, err := m.db.ListLinks(ctx, tenant, owner, after, limit)
+	m.observe("list_links", start, err)
+	return pairs, err
+}
+
+func (m *DBMetrics) ScanLinks(ctx context.Context, after string, limit int) ([]T.DBMess, error) {
+	start := time.Now()
+	pairs, err := m.db.ScanLinks(ctx, after, limit)
+	m.observe("scan_links", start, err)
+	return pairs, err
+}
+
+func (m *DBMetrics) ReplaceLinkPair(ctx context.Context, pair T.DBMess) error {
+	start := time.Now()
+	err := m.db.ReplaceLinkPair(ctx, pair)
+	m.observe("replace_link", start, err)
+	return err
+}
+
+func (m *DBMetrics) SaveLinkPairs(ctx context.Context, next func() (T.DBMess, error)) (int, error) {
+	start := time.Now()
+	n, err := m.db.SaveLinkPairs(ctx, next)
+	m.observe("save_links", start, err)
+	return n, err
+}
+
+func (m *DBMetrics) PurgeExpired(ctx context.Context, now time.Time) (int64, error) {
+	start := time.Now()
+	n, err := m.db.PurgeExpired(ctx, now)
+	m.observe("purge_expired", start, err)
+	return n, err
+}
+
+func (m *DBMetrics) SaveClicks(ctx context.Context, clicks []T.DBClick) error {
+	start := time.Now()
+	err := m.db.SaveClicks(ctx, clicks)
+	m.observe("save_clicks", start, err)
+	return err
+}
+
+func (m *DBMetrics) LoadLinkStats(ctx context.Context, hash string) (T.LinkStats, error) {
+	start := time.Now()
+	stats, err := m.db.LoadLinkStats(ctx, hash)
+	m.observe("load_stats", start, err)
+	return stats, err
+}
+
+func (m *DBMetrics) SaveAPIKey(ctx context.Context, key T.APIKey) error {
+	start := time.Now()
+	err := m.db.SaveAPIKey(ctx, key)
+	m.observe("save_key", start, err)
+	return err
+}
+
+func (m *DBMetrics) LoadAPIKey(ctx context.Context, hash string) (T.APIKey, error) {
+	start := time.Now()
+	key, err := m.db.LoadAPIKey(ctx, hash)
+	m.observe("load_key", start, err)
+	return key, err
+}
+
+func (m *DBMetrics) RevokeAPIKey(ctx context.Context, id string, now time.Time) error {
+	start := time.Now()
+	err := m.db.RevokeAPIKey(ctx, id, now)
+	m.observe("revoke_key", start, err)
+	return err
+}
+
+func (m *DBMetrics) ListAPIKeys(ctx context.Context) ([]T.APIKey, error) {
+	start := time.Now()
+	keys, err := m.db.ListAPIKeys(ctx)
+	m.observe("list_keys", start, err)
+	return keys, err
+}
+
+func (m *DBMetrics) ReserveCounter(ctx context.Context, name string, n uint64) (uint64, error) {
+	start := time.Now()
+	first, err := m.db.ReserveCounter(ctx, name, n)
+	m.observe("reserve_counter", start, err)
+	return first, err
+}
+
+func (m *DBMetrics) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := m.db.Ping(ctx)
+	m.observe("ping", start, err)
+	return err
+}
+
+func (m *DBMetrics) ConnectDB() (func(e error), error) {
+	return m.db.ConnectDB()
+}
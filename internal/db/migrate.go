@@ -0,0 +1,306 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	T "shortlink2/internal/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Schema migrations are sql files embedded into the binary, named <version>_<name>.sql with
+	versions going 1, 2, 3... Statements are split by ';' outside of literals, comments and
+	BEGIN...END bodies of triggers. Every migration runs in its own transaction and is recorded
+	in schema_version.
+
+	Never edit an applied migration, add the next one. Db files made before migrations have tables
+	but no schema_version, "already exists" and "duplicate column" errors are ignored for them.
+	Such files get version 0 "legacy" with schema_version, so the errors are still ignored when
+	a later migration failed and is run again.
+
+	shortlink2 migrate status
+	shortlink2 migrate up [-dry-run]
+*/
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// migrations are loaded once, a broken set is a build mistake like a bad regexp.MustCompile
+var migrations = loadMigrations(migrationFS)
+
+func loadMigrations(fsys fs.FS) []T.Migration {
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		panic(fmt.Errorf("%s: %w", "loadMigrations()", err))
+	}
+	list := make([]T.Migration, 0, len(files))
+	for i, file := range files { // Glob sorts names, versions have leading zeros
+		base := strings.TrimSuffix(path.Base(file), ".sql")
+		num, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if (err != nil) || (version != i+1) {
+			panic(fmt.Errorf("%s: %s: %s", "loadMigrations()", file, "versions must go 1, 2, 3... without gaps"))
+		}
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			panic(fmt.Errorf("%s: %w", "loadMigrations()", err))
+		}
+		list = append(list, T.Migration{Version: version, Name: name, SQL: string(body)})
+	}
+	return list
+}
+
+// statements drops comments and splits the migration by ';' outside of quotes, and outside of
+// BEGIN...END of CREATE TRIGGER where CASE...END is counted too
+func statements(sqlText string) []string {
+	stmts := []string{}
+	var stmt, word strings.Builder
+	words, depth := []string{}, 0
+	endWord := func() {
+		if word.Len() == 0 {
+			return
+		}
+		w := strings.ToUpper(word.String())
+		word.Reset()
+		if len(words) < 4 {
+			words = append(words, w)
+		}
+		if !isTrigger(words) {
+			return
+		}
+		switch w {
+		case "BEGIN", "CASE":
+			depth++
+		case "END":
+			depth--
+		}
+	}
+	endStmt := func() {
+		if s := strings.TrimSpace(stmt.String()); len(s) != 0 {
+			stmts = append(stmts, s)
+		}
+		stmt.Reset()
+		words, depth = words[:0], 0
+	}
+	for i := 0; i < len(sqlText); i++ {
+		c := sqlText[i]
+		switch {
+		case (c == '_') || ((c >= '0') && (c <= '9')) || ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z')):
+			word.WriteByte(c)
+			stmt.WriteByte(c)
+			continue
+		case strings.HasPrefix(sqlText[i:], "--"):
+			endWord()
+			if end := strings.IndexByte(sqlText[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(sqlText)
+			}
+			stmt.WriteByte('\n')
+			continue
+		case strings.HasPrefix(sqlText[i:], "/*"):
+			endWord()
+			if end := strings.Index(sqlText[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(sqlText)
+			}
+			stmt.WriteByte(' ')
+			continue
+		}
+		endWord()
+		switch c {
+		case '\'', '"', '`', '[': // quoted literal or identifier, doubled quotes inside are two literals in a row
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(sqlText[i+1:], closing)
+			if end < 0 { // not closed, sqlite reports it
+				stmt.WriteString(sqlText[i:])
+				i = len(sqlText)
+				continue
+			}
+			stmt.WriteString(sqlText[i : i+end+2])
+			i += end + 1
+		case ';':
+			if depth > 0 {
+				stmt.WriteByte(c)
+			} else {
+				endStmt()
+			}
+		default:
+			stmt.WriteByte(c)
+		}
+	}
+	endWord()
+	endStmt()
+	return stmts
+}
+
+// isTrigger tells by the first words if the statement is CREATE [TEMP|TEMPORARY] TRIGGER
+func isTrigger(words []string) bool {
+	if (len(words) < 2) || (words[0] != "CREATE") {
+		return false
+	}
+	if (words[1] == "TEMP") || (words[1] == "TEMPORARY") {
+		return (len(words) >= 3) && (words[2] == "TRIGGER")
+	}
+	return words[1] == "TRIGGER"
+}
+
+func (s *DBsqlite) tableExists(ctx context.Context, table string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n)
+	return n != 0, err
+}
+
+// applied gives applied times by version, empty for new and legacy db files
+func (s *DBsqlite) applied(ctx context.Context) (map[int]time.Time, error) {
+	versions := map[int]time.Time{}
+	ok, err1 := s.tableExists(ctx, "schema_version")
+	if (err1 != nil) || !ok {
+		return versions, err1
+	}
+	rows, err2 := s.db.QueryContext(ctx, "SELECT version, applied FROM schema_version")
+	if err2 != nil {
+		return nil, err2
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var applied int64
+		if err3 := rows.Scan(&version, &applied); err3 != nil {
+			return nil, err3
+		}
+		versions[version] = time.Unix(applied, 0)
+	}
+	return versions, rows.Err()
+}
+
+// Migrations lists migrations of the binary, and fails if the db has versions the binary does not know
+func (s *DBsqlite) Migrations(ctx context.Context) ([]T.Migration, error) {
+	versions, err := s.applied(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", "DBsqlite.Migrations(): unable to read schema_version", err)
+	}
+	for version := range versions {
+		if version > len(migrations) {
+			return nil, fmt.Errorf("%s: db schema version %d, binary knows up to %d", "DBsqlite.Migrations(): db is newer than the binary", version, len(migrations))
+		}
+	}
+	list := make([]T.Migration, len(migrations))
+	for i, m := range migrations {
+		m.Applied = versions[m.Version]
+		list[i] = m
+	}
+	return list, nil
+}
+
+func (s *DBsqlite) Migrate(ctx context.Context, dryRun bool) ([]T.Migration, error) {
+	list, err1 := s.Migrations(ctx)
+	if err1 != nil {
+		return nil, err1
+	}
+	pending := []T.Migration{}
+	for _, m := range list {
+		if m.Applied.IsZero() {
+			pending = append(pending, m)
+		}
+	}
+	if dryRun || (len(pending) == 0) {
+		return pending, nil
+	}
+	legacy, err2 := s.versionTable(ctx)
+	if err2 != nil {
+		return nil, err2
+	}
+	for i := range pending {
+		if err3 := s.apply(ctx, &pending[i], legacy); err3 != nil {
+			return pending[:i], err3
+		}
+		s.log.LogInfo("db schema migrated to version %d %s", pending[i].Version, pending[i].Name)
+	}
+	return pending, nil
+}
+
+// versionTable makes schema_version, legacy db files made before migrations have links but no
+// schema_version, they get version 0 in the same transaction, so the mode is kept across runs
+func (s *DBsqlite) versionTable(ctx context.Context) (bool, error) {
+	tx, err1 := s.db.BeginTx(ctx, nil)
+	if err1 != nil {
+		return false, fmt.Errorf("%s: %w", "DBsqlite.versionTable(): unable to BEGIN", err1)
+	}
+	defer tx.Rollback()
+	var versioned, legacy bool
+	err2 := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'), "+
+		"EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'shortlink')").Scan(&versioned, &legacy)
+	if err2 != nil {
+		return false, fmt.Errorf("%s: %w", "DBsqlite.versionTable(): unable to check tables", err2)
+	}
+	if versioned {
+		err3 := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_version WHERE version = 0)").Scan(&legacy)
+		if err3 != nil {
+			return false, fmt.Errorf("%s: %w", "DBsqlite.versionTable(): unable to SELECT schema_version", err3)
+		}
+		return legacy, nil
+	}
+	if _, err4 := tx.ExecContext(ctx, "CREATE TABLE schema_version (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied INTEGER NOT NULL)"); err4 != nil {
+		return false, fmt.Errorf("%s: %w", "DBsqlite.versionTable(): unable to CREATE TABLE schema_version", err4)
+	}
+	if legacy {
+		if _, err5 := tx.ExecContext(ctx, "INSERT INTO schema_version (version, name, applied) VALUES (0, 'legacy', ?)", time.Now().Unix()); err5 != nil {
+			return false, fmt.Errorf("%s: %w", "DBsqlite.versionTable(): unable to INSERT schema_version", err5)
+		}
+	}
+	if err6 := tx.Commit(); err6 != nil {
+		return false, fmt.Errorf("%s: %w", "DBsqlite.versionTable(): unable to COMMIT", err6)
+	}
+	return legacy, nil
+}
+
+func (s *DBsqlite) apply(ctx context.Context, m *T.Migration, legacy bool) error {
+	tx, err1 := s.db.BeginTx(ctx, nil)
+	if err1 != nil {
+		return fmt.Errorf("%s: %w", "DBsqlite.apply(): unable to BEGIN", err1)
+	}
+	defer tx.Rollback()
+	for _, stmt := range statements(m.SQL) {
+		_, err2 := tx.ExecContext(ctx, stmt)
+		if (err2 != nil) && !(legacy && isLegacyErr(err2)) {
+			return fmt.Errorf("DBsqlite.apply(): migration %d %s failed on %q: %w", m.Version, m.Name, stmt, err2)
+		}
+	}
+	now := time.Now()
+	if _, err3 := tx.ExecContext(ctx, "INSERT INTO schema_version (version, name, applied) VALUES (?, ?, ?)", m.Version, m.Name, now.Unix()); err3 != nil {
+		return fmt.Errorf("%s: %w", "DBsqlite.apply(): unable to INSERT schema_version", err3)
+	}
+	if err4 := tx.Commit(); err4 != nil {
+		return fmt.Errorf("%s: %w", "DBsqlite.apply(): unable to COMMIT", err4)
+	}
+	m.Applied = now
+	return nil
+}
+
+func isLegacyErr(err error) bool {
+	return strings.Contains(err.Error(), "already exists") || strings.Contains(err.Error(), "duplicate column name")
+}
+
+func (s *DBsqlite) ManualMigrate() {
+	s.manual = true
+}
+
+// migrateOnConnect brings the schema up to date, the app must not run on a schema it does not know;
+// in manual mode the schema is left to CLI commands, they report its state on their own
+func (s *DBsqlite) migrateOnConnect() error {
+	if s.manual {
+		return nil
+	}
+	_, err := s.Migrate(context.Background(), false)
+	return err
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	L "shortlink2/internal/log"
+	T "shortlink2/internal/types"
+	"testing"
+)
+
+type cfgMap map[string]string
+
+func (c cfgMap) GetVal(key string) string { return c[key] }
+func (c cfgMap) Parse() T.ICfg            { return c }
+func (c cfgMap) Validate() error          { return nil }
+
+// testCfg is the config of a db file in the temp dir of the test
+func testCfg(t *testing.T) cfgMap {
+	return cfgMap{
+		T.SL_LOG_LEVEL:       "NOLOG",
+		T.SL_DB_PATH:         filepath.Join(t.TempDir(), "test.db"),
+		T.SL_DB_JOURNAL:      "WAL",
+		T.SL_DB_SYNC:         "NORMAL",
+		T.SL_DB_BUSY_TIMEOUT: "5s",
+		T.SL_DB_CACHE_SIZE:   "-2000",
+		T.SL_DB_TIMEOUT:      "3s",
+	}
+}
+
+// openTestDB opens the db without migrations, InitDB or Migrate are up to the test
+func openTestDB(t *testing.T, cfg cfgMap) *DBsqlite {
+	s := NewDBsqlite(cfg, L.NewLogFprintf(cfg, 0), t.TempDir())
+	db, err := sql.Open("sqlite3", s.dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.db = db
+	t.Cleanup(func() { db.Close() })
+	return s
+}
+
+func TestStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"plain", "CREATE TABLE a (x INT);\nCREATE TABLE b (y INT);\n", []string{"CREATE TABLE a (x INT)", "CREATE TABLE b (y INT)"}},
+		{"comments", "-- a; b\nCREATE TABLE a (x INT); /* c; d */ DROP TABLE a; -- e", []string{"CREATE TABLE a (x INT)", "DROP TABLE a"}},
+		{"literals", "INSERT INTO a VALUES ('x;y', 'it''s;', \"c;d\", [e;f]);", []string{"INSERT INTO a VALUES ('x;y', 'it''s;', \"c;d\", [e;f])"}},
+		{"comment marks in literal", "INSERT INTO a VALUES ('--;/*');", []string{"INSERT INTO a VALUES ('--;/*')"}},
+		{"trigger", "CREATE TRIGGER t AFTER DELETE ON a BEGIN\n  DELETE FROM b WHERE x = CASE WHEN old.x > 0 THEN old.x ELSE 0 END;\n  DELETE FROM c;\nEND;\nDROP TABLE d;",
+			[]string{"CREATE TRIGGER t AFTER DELETE ON a BEGIN\n  DELETE FROM b WHERE x = CASE WHEN old.x > 0 THEN old.x ELSE 0 END;\n  DELETE FROM c;\nEND", "DROP TABLE d"}},
+		{"temp trigger", "create temp trigger t after insert on a begin delete from b; end; select 1",
+			[]string{"create temp trigger t after insert on a begin delete from b; end", "select 1"}},
+		{"case out of trigger", "SELECT CASE WHEN 1 THEN 2 END; SELECT 3", []string{"SELECT CASE WHEN 1 THEN 2 END", "SELECT 3"}},
+		{"empty", " ;\n-- only comment\n;", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statements(tt.sql); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("statements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrationsRun(t *testing.T) {
+	for _, m := range migrations {
+		s := openTestDB(t, testCfg(t))
+		if _, err := s.db.Exec("CREATE TABLE schema_version (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied INTEGER NOT NULL)"); err != nil {
+			t.Fatal(err)
+		}
+		for _, prev := range migrations[:m.Version-1] {
+			if err := s.apply(context.Background(), &prev, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.apply(context.Background(), &m, false); err != nil {
+			t.Fatalf("migration %d %s: %v", m.Version, m.Name, err)
+		}
+	}
+}
+
+func TestMigrateFresh(t *testing.T) {
+	ctx := context.Background()
+	s := openTestDB(t, testCfg(t))
+	pending, err := s.Migrate(ctx, true)
+	if err != nil || (len(pending) != len(migrations)) {
+		t.Fatalf("dry run: %d pending, %v", len(pending), err)
+	}
+	if ok, _ := s.tableExists(ctx, "schema_version"); ok {
+		t.Fatal("dry run made schema_version")
+	}
+	if applied, err := s.Migrate(ctx, false); err != nil || (len(applied) != len(migrations)) {
+		t.Fatalf("migrate: %d applied, %v", len(applied), err)
+	}
+	list, err := s.Migrations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range list {
+		if m.Applied.IsZero() {
+			t.Fatalf("migration %d is not applied", m.Version)
+		}
+	}
+	var legacy bool
+	s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_version WHERE version = 0)").Scan(&legacy)
+	if legacy {
+		t.Fatal("fresh db is marked legacy")
+	}
+	if again, err := s.Migrate(ctx, false); err != nil || (len(again) != 0) {
+		t.Fatalf("second migrate: %d applied, %v", len(again), err)
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	ctx := context.Background()
+	cfg := testCfg(t)
+	s := openTestDB(t, cfg)
+	_, err := s.db.Exec("CREATE TABLE shortlink (hash TEXT PRIMARY KEY, link TEXT NOT NULL, CHECK (link <> '')); INSERT INTO shortlink VALUES ('5clp60', 'http://lib.ru')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first run stops after schema_version is made, e.g. on a failed migration
+	if legacy, err := s.versionTable(ctx); err != nil || !legacy {
+		t.Fatalf("versionTable() = %v, %v, want legacy", legacy, err)
+	}
+	s.db.Close()
+
+	s = openTestDB(t, cfg)
+	if _, err := s.Migrate(ctx, false); err != nil {
+		t.Fatalf("second run lost the legacy mode: %v", err)
+	}
+	pair, err := s.LoadLinkPair(ctx, "5clp60")
+	if err != nil || (pair.Link != "http://lib.ru") {
+		t.Fatalf("legacy link: %+v, %v", pair, err)
+	}
+	list, err := s.Migrations(ctx)
+	if err != nil || (len(list) != len(migrations)) {
+		t.Fatalf("migrations: %d, %v", len(list), err)
+	}
+}
+
+func TestMigrateNewerDB(t *testing.T) {
+	ctx := context.Background()
+	cfg := testCfg(t)
+	s := openTestDB(t, cfg)
+	if _, err := s.Migrate(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("INSERT INTO schema_version VALUES (?, 'future', 0)", len(migrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Migrations(ctx); err == nil {
+		t.Fatal("no error for a db newer than the binary")
+	}
+	if err := s.InitDB(); err == nil {
+		t.Fatal("InitDB() runs on a db newer than the binary")
+	}
+	// the log does not stop the process at NOLOG, the server must stop on the error
+	server := NewDBsqlite(cfg, L.NewLogFprintf(cfg, 0), t.TempDir())
+	if _, err := server.ConnectDB(); err == nil {
+		t.Fatal("ConnectDB() starts on a db newer than the binary")
+	}
+	if server.db != nil {
+		t.Fatal("ConnectDB() keeps the db open after the error")
+	}
+	s.ManualMigrate()
+	if err := s.InitDB(); err != nil {
+		t.Fatalf("manual mode leaves the check to CLI, got %v", err)
+	}
+}
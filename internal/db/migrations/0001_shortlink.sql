@@ -0,0 +1,2 @@
+-- links by hash, the first schema of shortlink2
+CREATE TABLE shortlink (hash TEXT PRIMARY KEY, link TEXT NOT NULL, CHECK (link <> ''));
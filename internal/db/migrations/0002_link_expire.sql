@@ -0,0 +1,3 @@
+-- link expiration, 0 means the link never expires
+ALTER TABLE shortlink ADD COLUMN expire INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX shortlink_expire ON shortlink (expire) WHERE expire > 0;
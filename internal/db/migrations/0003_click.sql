@@ -0,0 +1,3 @@
+-- click events of redirects for link stats
+CREATE TABLE click (hash TEXT NOT NULL, time INTEGER NOT NULL, referer TEXT NOT NULL, agent TEXT NOT NULL);
+CREATE INDEX click_hash ON click (hash, time);
@@ -0,0 +1,2 @@
+-- API keys, only sha256 of tokens is stored
+CREATE TABLE apikey (id TEXT PRIMARY KEY, name TEXT NOT NULL, hash TEXT NOT NULL UNIQUE, admin INTEGER NOT NULL, created INTEGER NOT NULL, revoked INTEGER NOT NULL DEFAULT 0);
@@ -0,0 +1,5 @@
+-- link owners and tenants of links and keys
+ALTER TABLE shortlink ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+ALTER TABLE shortlink ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+ALTER TABLE apikey ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+CREATE INDEX shortlink_tenant ON shortlink (tenant, hash);
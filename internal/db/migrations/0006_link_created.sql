@@ -0,0 +1,2 @@
+-- creation time of links for export, 0 for links made before it
+ALTER TABLE shortlink ADD COLUMN created INTEGER NOT NULL DEFAULT 0;
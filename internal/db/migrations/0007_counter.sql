@@ -0,0 +1,2 @@
+-- high water marks of counter hash generators, so hashes are not reused after restarts
+CREATE TABLE counter (name TEXT PRIMARY KEY, value INTEGER NOT NULL);
@@ -1,9 +1,14 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	T "shortlink2/internal/types"
+	"sort"
 	"sync"
+	"time"
 )
 
 var _ T.IDB = (*DBmock)(nil)
@@ -11,50 +16,314 @@ var _ T.IDB = (*DBmock)(nil)
 type DBmock struct {
 	log  T.ILog
 	cfg  T.ICfg
-	db   map[string]string
+	db   map[string]T.DBMess
+	clks map[string][]T.DBClick
+	keys map[string]T.APIKey // by hash
+	cnts map[string]uint64
 	rwmu sync.RWMutex
 }
 
 func NewDBmock(cfg T.ICfg, log T.ILog) *DBmock {
-	mockdb := make(map[string]string, 8)
-	mockdb["5clp60"] = "http://lib.ru"
-	// dbmock.Store("5clp60", "http://lib.ru")
-	// dbmock.Store("dhiu79", "http://google.ru")
 	return &DBmock{
-		log: log,
-		cfg: cfg,
-		db:  mockdb,
+		log:  log,
+		cfg:  cfg,
+		db:   make(map[string]T.DBMess, 8),
+		clks: make(map[string][]T.DBClick, 8),
+		keys: make(map[string]T.APIKey, 8),
+		cnts: make(map[string]uint64, 2),
 	}
 }
 
-func (m *DBmock) SaveLinkPair(hash, link string) bool {
-	if (len(hash) == 0) || (len(link) == 0) {
-		return false
+func (m *DBmock) SaveLinkPair(ctx context.Context, pair T.DBMess) error {
+	if err := m.ctxErr(ctx); err != nil {
+		return err
+	}
+	if (len(pair.Hash) == 0) || (len(pair.Link) == 0) {
+		return fmt.Errorf("%w: %s", T.ErrInvalidLink, "empty hash or link")
 	}
 	m.rwmu.Lock()
-	m.db[hash] = link
-	m.rwmu.Unlock()
-	return true
+	defer m.rwmu.Unlock()
+	if _, ok := m.db[pair.Hash]; ok { // same as sqlite UNIQUE constraint, never overwrite
+		return fmt.Errorf("%w: %s", T.ErrConflict, pair.Hash)
+	}
+	m.db[pair.Hash] = pair
+	return nil
 }
 
-func (m *DBmock) LoadLinkPair(hash string) string {
+func (m *DBmock) LoadLinkPair(ctx context.Context, hash string) (T.DBMess, error) {
+	if err := m.ctxErr(ctx); err != nil {
+		return T.DBMess{}, err
+	}
 	m.rwmu.RLock()
-	link, ok := m.db[hash]
+	pair, ok := m.db[hash]
 	m.rwmu.RUnlock()
 	if !ok {
-		return ""
+		return T.DBMess{}, T.ErrNotFound
+	}
+	return pair, nil
+}
+
+func (m *DBmock) ListLinks(ctx context.Context, tenant, owner, after string, limit int) ([]T.DBMess, error) {
+	if err := m.ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	pairs := []T.DBMess{}
+	m.rwmu.RLock()
+	for hash, pair := range m.db {
+		if (pair.Tenant == tenant) && ((len(owner) == 0) || (pair.Owner == owner)) && (hash > after) {
+			pairs = append(pairs, pair)
+		}
+	}
+	m.rwmu.RUnlock()
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Hash < pairs[j].Hash })
+	if len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+	return pairs, nil
+}
+
+func (m *DBmock) ScanLinks(ctx context.Context, after string, limit int) ([]T.DBMess, error) {
+	if err := m.ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	pairs := []T.DBMess{}
+	m.rwmu.RLock()
+	for hash, pair := range m.db {
+		if hash > after {
+			pairs = append(pairs, pair)
+		}
+	}
+	m.rwmu.RUnlock()
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Hash < pairs[j].Hash })
+	if len(pairs) > limit {
+		pairs = pairs[:limit]
 	}
-	return link
+	return pairs, nil
+}
+
+func (m *DBmock) ReplaceLinkPair(ctx context.Context, pair T.DBMess) error {
+	if err := m.ctxErr(ctx); err != nil {
+		return err
+	}
+	if (len(pair.Hash) == 0) || (len(pair.Link) == 0) {
+		return fmt.Errorf("%w: %s", T.ErrInvalidLink, "empty hash or link")
+	}
+	m.rwmu.Lock()
+	m.db[pair.Hash] = pair
+	delete(m.clks, pair.Hash)
+	m.rwmu.Unlock()
+	return nil
 }
 
-func (m *DBmock) DeleteLinkPair(hash string) bool {
+// SaveLinkPairs collects all pairs first, so the map is not changed on errors
+func (m *DBmock) SaveLinkPairs(ctx context.Context, next func() (T.DBMess, error)) (int, error) {
+	pairs := map[string]T.DBMess{}
 	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	for {
+		if err := m.ctxErr(ctx); err != nil {
+			return 0, err
+		}
+		pair, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if (len(pair.Hash) == 0) || (len(pair.Link) == 0) {
+			return 0, fmt.Errorf("%w: %s", T.ErrInvalidLink, "empty hash or link")
+		}
+		_, taken := m.db[pair.Hash]
+		if _, twice := pairs[pair.Hash]; taken || twice {
+			return 0, fmt.Errorf("%w: %s", T.ErrConflict, pair.Hash)
+		}
+		pairs[pair.Hash] = pair
+	}
+	for hash, pair := range pairs {
+		m.db[hash] = pair
+	}
+	return len(pairs), nil
+}
+
+func (m *DBmock) UpdateLinkPair(ctx context.Context, hash, link string, expire time.Time) error {
+	if err := m.ctxErr(ctx); err != nil {
+		return err
+	}
+	if len(link) == 0 {
+		return fmt.Errorf("%w: %s", T.ErrInvalidLink, "empty link")
+	}
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	pair, ok := m.db[hash]
+	if !ok {
+		return T.ErrNotFound
+	}
+	pair.Link, pair.Expire = link, expire
+	m.db[hash] = pair
+	return nil
+}
+
+func (m *DBmock) DeleteLinkPair(ctx context.Context, hash string) error {
+	if err := m.ctxErr(ctx); err != nil {
+		return err
+	}
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	if _, ok := m.db[hash]; !ok {
+		return T.ErrNotFound
+	}
 	delete(m.db, hash)
+	delete(m.clks, hash)
+	return nil
+}
+
+func (m *DBmock) PurgeExpired(ctx context.Context, now time.Time) (int64, error) {
+	if err := m.ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	var n int64
+	m.rwmu.Lock()
+	for hash, pair := range m.db {
+		if pair.IsExpired(now) {
+			delete(m.db, hash)
+			delete(m.clks, hash)
+			n++
+		}
+	}
 	m.rwmu.Unlock()
-	return true
+	return n, nil
 }
 
-func (m *DBmock) ConnectDB() func(e error) {
+func (m *DBmock) SaveClicks(ctx context.Context, clicks []T.DBClick) error {
+	if err := m.ctxErr(ctx); err != nil {
+		return err
+	}
+	m.rwmu.Lock()
+	for _, click := range clicks {
+		m.clks[click.Hash] = append(m.clks[click.Hash], click)
+	}
+	m.rwmu.Unlock()
+	return nil
+}
+
+func (m *DBmock) LoadLinkStats(ctx context.Context, hash string) (T.LinkStats, error) {
+	if err := m.ctxErr(ctx); err != nil {
+		return T.LinkStats{}, err
+	}
+	stats := T.LinkStats{
+		Hash:     hash,
+		Referers: make(map[string]int64),
+		Agents:   make(map[string]int64),
+		Days:     make(map[string]int64),
+	}
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	for _, click := range m.clks[hash] {
+		t := click.Time
+		if (stats.First == nil) || t.Before(*stats.First) {
+			stats.First = &t
+		}
+		if (stats.Last == nil) || t.After(*stats.Last) {
+			stats.Last = &t
+		}
+		stats.Clicks++
+		stats.Referers[click.Referer]++
+		stats.Agents[click.Agent]++
+		stats.Days[t.UTC().Format(time.DateOnly)]++
+	}
+	return stats, nil
+}
+
+func (m *DBmock) SaveAPIKey(ctx context.Context, key T.APIKey) error {
+	if err := m.ctxErr(ctx); err != nil {
+		return err
+	}
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	for _, k := range m.keys {
+		if (k.ID == key.ID) || (k.Hash == key.Hash) {
+			return fmt.Errorf("%w: %s", T.ErrConflict, key.ID)
+		}
+	}
+	m.keys[key.Hash] = key
+	return nil
+}
+
+func (m *DBmock) LoadAPIKey(ctx context.Context, hash string) (T.APIKey, error) {
+	if err := m.ctxErr(ctx); err != nil {
+		return T.APIKey{}, err
+	}
+	m.rwmu.RLock()
+	key, ok := m.keys[hash]
+	m.rwmu.RUnlock()
+	if !ok {
+		return T.APIKey{}, T.ErrNotFound
+	}
+	return key, nil
+}
+
+func (m *DBmock) RevokeAPIKey(ctx context.Context, id string, now time.Time) error {
+	if err := m.ctxErr(ctx); err != nil {
+		return err
+	}
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	for hash, key := range m.keys {
+		if (key.ID == id) && key.Revoked.IsZero() {
+			key.Revoked = now
+			m.keys[hash] = key
+			return nil
+		}
+	}
+	return T.ErrNotFound
+}
+
+func (m *DBmock) ListAPIKeys(ctx context.Context) ([]T.APIKey, error) {
+	if err := m.ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	m.rwmu.RLock()
+	keys := make([]T.APIKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		keys = append(keys, key)
+	}
+	m.rwmu.RUnlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Created.Equal(keys[j].Created) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].Created.Before(keys[j].Created)
+	})
+	return keys, nil
+}
+
+func (m *DBmock) ReserveCounter(ctx context.Context, name string, n uint64) (uint64, error) {
+	if err := m.ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	if _, ok := m.cnts[name]; !ok {
+		m.cnts[name] = uint64(time.Now().Unix())
+	}
+	m.cnts[name] += n
+	return m.cnts[name] - n + 1, nil
+}
+
+// ctxErr gives the same error for done context as sqlite does, mock operations never block for long
+func (m *DBmock) ctxErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %w", T.ErrUnavailable, err)
+	}
+	return nil
+}
+
+func (m *DBmock) Ping(ctx context.Context) error {
+	return m.ctxErr(ctx)
+}
+
+func (m *DBmock) ConnectDB() (func(e error), error) {
 	m.log.LogInfo("mock db connected")
 	return func(e error) {
 		if e != nil {
@@ -62,5 +331,5 @@ func (m *DBmock) ConnectDB() func(e error) {
 			m.log.LogError(fmt.Errorf("%s: %w", "DBmock.Connect(): db graceful_shutdown with error", e))
 		}
 		m.log.LogInfo("mock db disconnected")
-	}
+	}, nil
 }
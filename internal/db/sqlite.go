@@ -1,97 +1,521 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 	T "shortlink2/internal/types"
-	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	sqlite3 "github.com/mattn/go-sqlite3"
 )
 
 var _ T.IDB = (*DBsqlite)(nil)
+var _ T.IDBMigrator = (*DBsqlite)(nil)
 
 type DBsqlite struct {
-	log    T.ILog
-	cfg    T.ICfg
-	dbpath string
-	db     *sql.DB
+	log      T.ILog
+	cfg      T.ICfg
+	dbpath   string
+	dsn      string // dbpath with driver params of pragmas
+	dsnErr   error  // bad SL_DB_* values, ConnectDB refuses them
+	db       *sql.DB
+	timeout  time.Duration
+	manual   bool       // migrations are left to CLI
+	backupMu sync.Mutex // one snapshot at a time
 }
 
 func NewDBsqlite(cfg T.ICfg, log T.ILog, dir string) *DBsqlite {
+	dbpath, dsn, dsnErr := sqliteDSN(cfg, dir)
 	return &DBsqlite{
-		log:    log,
-		cfg:    cfg,
-		dbpath: filepath.Join(dir, "db/sqlite.db"),
+		log:     log,
+		cfg:     cfg,
+		dbpath:  dbpath,
+		dsn:     dsn,
+		dsnErr:  dsnErr,
+		timeout: opTimeout(cfg, log),
 	}
 }
 
-func (s *DBsqlite) SaveLinkPair(hash, link string) bool {
-	if err := s.db.Ping(); err != nil {
-		s.log.LogError(fmt.Errorf("%s: %w", "DBsqlite.SaveLinkPair(): unable to ping db", err))
-		return false
+func (s *DBsqlite) SaveLinkPair(ctx context.Context, pair T.DBMess) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return s.errUnavailable(ctx, "DBsqlite.SaveLinkPair(): unable to ping db", err)
 	}
-	_, err1 := s.db.Exec("INSERT INTO shortlink VALUES (?, ?)", hash, link)
+	_, err1 := s.db.ExecContext(ctx, "INSERT INTO shortlink (hash, link, expire, owner, tenant, created) VALUES (?, ?, ?, ?, ?, ?)",
+		pair.Hash, pair.Link, unixOrZero(pair.Expire), pair.Owner, pair.Tenant, unixOrZero(pair.Created))
 	if err1 != nil {
-		s.log.LogError(fmt.Errorf("%s: %w", "DBsqlite.SaveLinkPair(): unable to INSERT values", err1))
-		return false
+		if err2 := constraintErr(err1, pair); err2 != nil {
+			return err2
+		}
+		return s.errUnavailable(ctx, "DBsqlite.SaveLinkPair(): unable to INSERT values", err1)
 	}
-	return true
+	return nil
 }
 
-func (s *DBsqlite) LoadLinkPair(hash string) string {
-	if err := s.db.Ping(); err != nil {
-		s.log.LogError(fmt.Errorf("%s: %w", "DBsqlite.LoadLinkPair(): unable to ping db", err))
-		return ""
+// constraintErr maps CHECK to ErrInvalidLink and UNIQUE to ErrConflict, nil for other errors
+func constraintErr(err error, pair T.DBMess) error {
+	var sqlErr sqlite3.Error
+	if !errors.As(err, &sqlErr) || (sqlErr.Code != sqlite3.ErrConstraint) {
+		return nil
+	}
+	if sqlErr.ExtendedCode == sqlite3.ErrConstraintCheck {
+		return fmt.Errorf("%w: %s", T.ErrInvalidLink, pair.Link)
+	}
+	return fmt.Errorf("%w: %s", T.ErrConflict, pair.Hash)
+}
+
+// SaveLinkPairs runs in one transaction without the operation timeout, it takes as long as next
+// gives pairs, so callers bound it by ctx and give pairs from a local file
+func (s *DBsqlite) SaveLinkPairs(ctx context.Context, next func() (T.DBMess, error)) (int, error) {
+	if err := s.db.PingContext(ctx); err != nil {
+		return 0, s.errUnavailable(ctx, "DBsqlite.SaveLinkPairs(): unable to ping db", err)
+	}
+	tx, err1 := s.db.BeginTx(ctx, nil)
+	if err1 != nil {
+		return 0, s.errUnavailable(ctx, "DBsqlite.SaveLinkPairs(): unable to BEGIN", err1)
+	}
+	defer tx.Rollback()
+	stmt, err2 := tx.PrepareContext(ctx, "INSERT INTO shortlink (hash, link, expire, owner, tenant, created) VALUES (?, ?, ?, ?, ?, ?)")
+	if err2 != nil {
+		return 0, s.errUnavailable(ctx, "DBsqlite.SaveLinkPairs(): unable to prepare INSERT", err2)
+	}
+	defer stmt.Close()
+	n := 0
+	for {
+		pair, err3 := next()
+		if errors.Is(err3, io.EOF) {
+			break
+		}
+		if err3 != nil {
+			return 0, err3
+		}
+		if _, err4 := stmt.ExecContext(ctx, pair.Hash, pair.Link, unixOrZero(pair.Expire), pair.Owner, pair.Tenant, unixOrZero(pair.Created)); err4 != nil {
+			if err5 := constraintErr(err4, pair); err5 != nil {
+				return 0, err5
+			}
+			return 0, s.errUnavailable(ctx, "DBsqlite.SaveLinkPairs(): unable to INSERT values", err4)
+		}
+		n++
+	}
+	if err6 := tx.Commit(); err6 != nil {
+		return 0, s.errUnavailable(ctx, "DBsqlite.SaveLinkPairs(): unable to COMMIT", err6)
+	}
+	return n, nil
+}
+
+func (s *DBsqlite) LoadLinkPair(ctx context.Context, hash string) (T.DBMess, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return T.DBMess{}, s.errUnavailable(ctx, "DBsqlite.LoadLinkPair(): unable to ping db", err)
+	}
+	row := s.db.QueryRowContext(ctx, "SELECT hash, link, expire, owner, tenant, created FROM shortlink WHERE hash = ?", hash)
+	pair, err1 := scanLinkPair(row.Scan)
+	if errors.Is(err1, sql.ErrNoRows) {
+		return T.DBMess{}, T.ErrNotFound
+	}
+	if err1 != nil {
+		return T.DBMess{}, s.errUnavailable(ctx, "DBsqlite.LoadLinkPair(): unable to SELECT values", err1)
+	}
+	return pair, nil
+}
+
+func (s *DBsqlite) ListLinks(ctx context.Context, tenant, owner, after string, limit int) ([]T.DBMess, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return nil, s.errUnavailable(ctx, "DBsqlite.ListLinks(): unable to ping db", err)
+	}
+	return s.queryLinkPairs(ctx, "DBsqlite.ListLinks()",
+		"SELECT hash, link, expire, owner, tenant, created FROM shortlink WHERE tenant = ? AND (? = '' OR owner = ?) AND hash > ? ORDER BY hash LIMIT ?",
+		tenant, owner, owner, after, limit)
+}
+
+func (s *DBsqlite) ScanLinks(ctx context.Context, after string, limit int) ([]T.DBMess, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return nil, s.errUnavailable(ctx, "DBsqlite.ScanLinks(): unable to ping db", err)
+	}
+	return s.queryLinkPairs(ctx, "DBsqlite.ScanLinks()",
+		"SELECT hash, link, expire, owner, tenant, created FROM shortlink WHERE hash > ? ORDER BY hash LIMIT ?", after, limit)
+}
+
+func (s *DBsqlite) queryLinkPairs(ctx context.Context, method, query string, args ...any) ([]T.DBMess, error) {
+	rows, err1 := s.db.QueryContext(ctx, query, args...)
+	if err1 != nil {
+		return nil, s.errUnavailable(ctx, method+": unable to SELECT values", err1)
+	}
+	defer rows.Close()
+	pairs := []T.DBMess{}
+	for rows.Next() {
+		pair, err2 := scanLinkPair(rows.Scan)
+		if err2 != nil {
+			return nil, s.errUnavailable(ctx, method+": unable to scan values", err2)
+		}
+		pairs = append(pairs, pair)
+	}
+	if err3 := rows.Err(); err3 != nil {
+		return nil, s.errUnavailable(ctx, method+": rows error", err3)
 	}
+	return pairs, nil
+}
+
+// ReplaceLinkPair overwrites the pair and deletes its clicks in one transaction, so the new link
+// never gets clicks of the old one
+func (s *DBsqlite) ReplaceLinkPair(ctx context.Context, pair T.DBMess) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return s.errUnavailable(ctx, "DBsqlite.ReplaceLinkPair(): unable to ping db", err)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return s.errUnavailable(ctx, "DBsqlite.ReplaceLinkPair(): unable to BEGIN", err)
+	}
+	defer tx.Rollback()
+	_, err1 := tx.ExecContext(ctx, "INSERT INTO shortlink (hash, link, expire, owner, tenant, created) VALUES (?, ?, ?, ?, ?, ?) "+
+		"ON CONFLICT (hash) DO UPDATE SET link = excluded.link, expire = excluded.expire, owner = excluded.owner, tenant = excluded.tenant, created = excluded.created",
+		pair.Hash, pair.Link, unixOrZero(pair.Expire), pair.Owner, pair.Tenant, unixOrZero(pair.Created))
+	if err1 != nil {
+		var sqlErr sqlite3.Error
+		if errors.As(err1, &sqlErr) && (sqlErr.ExtendedCode == sqlite3.ErrConstraintCheck) {
+			return fmt.Errorf("%w: %s", T.ErrInvalidLink, pair.Link)
+		}
+		return s.errUnavailable(ctx, "DBsqlite.ReplaceLinkPair(): unable to UPSERT values", err1)
+	}
+	if _, err2 := tx.ExecContext(ctx, "DELETE FROM click WHERE hash = ?", pair.Hash); err2 != nil {
+		return s.errUnavailable(ctx, "DBsqlite.ReplaceLinkPair(): unable to DELETE clicks", err2)
+	}
+	if err3 := tx.Commit(); err3 != nil {
+		return s.errUnavailable(ctx, "DBsqlite.ReplaceLinkPair(): unable to COMMIT", err3)
+	}
+	return nil
+}
+
+func scanLinkPair(scan func(dest ...any) error) (T.DBMess, error) {
 	var pair T.DBMess
-	err1 := s.db.QueryRow("SELECT hash, link FROM shortlink WHERE hash = ?", hash).Scan(&(pair.Hash), &(pair.Link))
+	var expire, created int64
+	if err := scan(&(pair.Hash), &(pair.Link), &expire, &(pair.Owner), &(pair.Tenant), &created); err != nil {
+		return T.DBMess{}, err
+	}
+	if expire != 0 {
+		pair.Expire = time.Unix(expire, 0)
+	}
+	if created != 0 {
+		pair.Created = time.Unix(created, 0)
+	}
+	return pair, nil
+}
+
+func (s *DBsqlite) UpdateLinkPair(ctx context.Context, hash, link string, expire time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return s.errUnavailable(ctx, "DBsqlite.UpdateLinkPair(): unable to ping db", err)
+	}
+	res, err1 := s.db.ExecContext(ctx, "UPDATE shortlink SET link = ?, expire = ? WHERE hash = ?", link, unixOrZero(expire), hash)
 	if err1 != nil {
-		s.log.LogDebug("DBsqlite.LoadLinkPair(): unable to SELECT values")
-		return ""
+		var sqlErr sqlite3.Error
+		if errors.As(err1, &sqlErr) && (sqlErr.ExtendedCode == sqlite3.ErrConstraintCheck) {
+			return fmt.Errorf("%w: %s", T.ErrInvalidLink, link)
+		}
+		return s.errUnavailable(ctx, "DBsqlite.UpdateLinkPair(): unable to UPDATE values", err1)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return T.ErrNotFound
 	}
-	return pair.Link
+	return nil
 }
 
-func (s *DBsqlite) DeleteLinkPair(hash string) bool {
-	if err := s.db.Ping(); err != nil {
-		s.log.LogError(fmt.Errorf("%s: %w", "DBsqlite.DeleteLinkPair(): unable to ping db", err))
-		return false
+// DeleteLinkPair deletes the link and its clicks in one transaction, so a reclaimed hash never gets them
+func (s *DBsqlite) DeleteLinkPair(ctx context.Context, hash string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return s.errUnavailable(ctx, "DBsqlite.DeleteLinkPair(): unable to ping db", err)
 	}
-	_, err1 := s.db.Exec("DELETE FROM shortlink WHERE hash = ?", hash)
+	tx, err1 := s.db.BeginTx(ctx, nil)
 	if err1 != nil {
-		s.log.LogError(fmt.Errorf("%s: %w", "DBsqlite.DeleteLinkPair(): unable to DELETE values", err1))
-		return false
+		return s.errUnavailable(ctx, "DBsqlite.DeleteLinkPair(): unable to BEGIN", err1)
+	}
+	defer tx.Rollback()
+	res, err2 := tx.ExecContext(ctx, "DELETE FROM shortlink WHERE hash = ?", hash)
+	if err2 != nil {
+		return s.errUnavailable(ctx, "DBsqlite.DeleteLinkPair(): unable to DELETE values", err2)
 	}
-	return true
+	if n, _ := res.RowsAffected(); n == 0 {
+		return T.ErrNotFound
+	}
+	if _, err3 := tx.ExecContext(ctx, "DELETE FROM click WHERE hash = ?", hash); err3 != nil {
+		return s.errUnavailable(ctx, "DBsqlite.DeleteLinkPair(): unable to DELETE clicks", err3)
+	}
+	if err4 := tx.Commit(); err4 != nil {
+		return s.errUnavailable(ctx, "DBsqlite.DeleteLinkPair(): unable to COMMIT", err4)
+	}
+	return nil
 }
 
-func (s *DBsqlite) InitDB() {
-	if err := s.db.Ping(); err != nil {
-		s.log.LogError(fmt.Errorf("%s: %w", "DBsqlite.InitDB(): unable to ping db", err))
-		return
+func (s *DBsqlite) PurgeExpired(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return 0, s.errUnavailable(ctx, "DBsqlite.PurgeExpired(): unable to ping db", err)
 	}
-	_, err1 := s.db.Exec("CREATE TABLE IF NOT EXISTS shortlink (hash TEXT PRIMARY KEY, link TEXT NOT NULL, CHECK (link <> ''))")
+	_, err1 := s.db.ExecContext(ctx, "DELETE FROM click WHERE hash IN (SELECT hash FROM shortlink WHERE expire > 0 AND expire <= ?)", now.Unix())
 	if err1 != nil {
-		s.log.LogError(fmt.Errorf("%s: %w", "DBsqlite.InitDB(): unable to CREATE TABLE", err1))
-		return
+		return 0, s.errUnavailable(ctx, "DBsqlite.PurgeExpired(): unable to DELETE clicks", err1)
 	}
-	_, err2 := s.db.Exec("INSERT INTO shortlink VALUES ('5clp60', 'http://lib.ru'); INSERT INTO shortlink VALUES ('dhiu79', 'http://google.ru');")
-	if err2 != nil && !strings.Contains(err2.Error(), "UNIQUE constraint failed") {
-		s.log.LogError(fmt.Errorf("%s: %w", "DBsqlite.InitDB(): unable to INSERT values", err2))
-		return
+	res, err2 := s.db.ExecContext(ctx, "DELETE FROM shortlink WHERE expire > 0 AND expire <= ?", now.Unix())
+	if err2 != nil {
+		return 0, s.errUnavailable(ctx, "DBsqlite.PurgeExpired(): unable to DELETE values", err2)
 	}
+	n, _ := res.RowsAffected()
+	return n, nil
 }
 
-func (s *DBsqlite) ConnectDB() func(e error) {
-	db, err := sql.Open("sqlite3", s.dbpath)
+func (s *DBsqlite) SaveClicks(ctx context.Context, clicks []T.DBClick) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return s.errUnavailable(ctx, "DBsqlite.SaveClicks(): unable to ping db", err)
+	}
+	tx, err1 := s.db.BeginTx(ctx, nil)
+	if err1 != nil {
+		return s.errUnavailable(ctx, "DBsqlite.SaveClicks(): unable to BEGIN transaction", err1)
+	}
+	defer tx.Rollback()
+	stmt, err2 := tx.PrepareContext(ctx, "INSERT INTO click (hash, time, referer, agent) VALUES (?, ?, ?, ?)")
+	if err2 != nil {
+		return s.errUnavailable(ctx, "DBsqlite.SaveClicks(): unable to prepare INSERT", err2)
+	}
+	defer stmt.Close()
+	for _, click := range clicks {
+		if _, err3 := stmt.ExecContext(ctx, click.Hash, click.Time.Unix(), click.Referer, click.Agent); err3 != nil {
+			return s.errUnavailable(ctx, "DBsqlite.SaveClicks(): unable to INSERT values", err3)
+		}
+	}
+	if err4 := tx.Commit(); err4 != nil {
+		return s.errUnavailable(ctx, "DBsqlite.SaveClicks(): unable to COMMIT transaction", err4)
+	}
+	return nil
+}
+
+// ReserveCounter takes n values in one UPSERT, a new counter starts after the current unix time, so
+// it goes above values of counters seeded by time before the counter table was made
+func (s *DBsqlite) ReserveCounter(ctx context.Context, name string, n uint64) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var last uint64
+	err := s.db.QueryRowContext(ctx, "INSERT INTO counter (name, value) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET value = value + ? RETURNING value",
+		name, uint64(time.Now().Unix())+n, n).Scan(&last)
 	if err != nil {
-		s.log.LogError(fmt.Errorf("%s: %w", "DBsqlite.ConnectDB(): unable to connect", err))
-		return func(e error) {}
+		return 0, s.errUnavailable(ctx, "DBsqlite.ReserveCounter(): unable to UPSERT counter", err)
+	}
+	return last - n + 1, nil
+}
+
+func (s *DBsqlite) LoadLinkStats(ctx context.Context, hash string) (T.LinkStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	stats := T.LinkStats{Hash: hash}
+	if err := s.db.PingContext(ctx); err != nil {
+		return stats, s.errUnavailable(ctx, "DBsqlite.LoadLinkStats(): unable to ping db", err)
+	}
+	var first, last sql.NullInt64
+	err1 := s.db.QueryRowContext(ctx, "SELECT COUNT(*), MIN(time), MAX(time) FROM click WHERE hash = ?", hash).Scan(&(stats.Clicks), &first, &last)
+	if err1 != nil {
+		return stats, s.errUnavailable(ctx, "DBsqlite.LoadLinkStats(): unable to SELECT totals", err1)
+	}
+	if first.Valid && last.Valid {
+		tfirst, tlast := time.Unix(first.Int64, 0), time.Unix(last.Int64, 0)
+		stats.First, stats.Last = &tfirst, &tlast
+	}
+	var err2, err3, err4 error
+	stats.Referers, err2 = s.groupClicks(ctx, "referer", hash)
+	stats.Agents, err3 = s.groupClicks(ctx, "agent", hash)
+	stats.Days, err4 = s.groupClicks(ctx, "date(time, 'unixepoch')", hash)
+	return stats, errors.Join(err2, err3, err4)
+}
+
+func (s *DBsqlite) SaveAPIKey(ctx context.Context, key T.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return s.errUnavailable(ctx, "DBsqlite.SaveAPIKey(): unable to ping db", err)
+	}
+	_, err1 := s.db.ExecContext(ctx, "INSERT INTO apikey (id, name, hash, tenant, admin, created, revoked) VALUES (?, ?, ?, ?, ?, ?, 0)",
+		key.ID, key.Name, key.Hash, key.Tenant, key.Admin, key.Created.Unix())
+	if err1 != nil {
+		var sqlErr sqlite3.Error
+		if errors.As(err1, &sqlErr) && (sqlErr.Code == sqlite3.ErrConstraint) {
+			return fmt.Errorf("%w: %s", T.ErrConflict, key.ID)
+		}
+		return s.errUnavailable(ctx, "DBsqlite.SaveAPIKey(): unable to INSERT values", err1)
+	}
+	return nil
+}
+
+func (s *DBsqlite) LoadAPIKey(ctx context.Context, hash string) (T.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return T.APIKey{}, s.errUnavailable(ctx, "DBsqlite.LoadAPIKey(): unable to ping db", err)
+	}
+	row := s.db.QueryRowContext(ctx, "SELECT id, name, hash, tenant, admin, created, revoked FROM apikey WHERE hash = ?", hash)
+	key, err1 := scanAPIKey(row.Scan)
+	if errors.Is(err1, sql.ErrNoRows) {
+		return T.APIKey{}, T.ErrNotFound
+	}
+	if err1 != nil {
+		return T.APIKey{}, s.errUnavailable(ctx, "DBsqlite.LoadAPIKey(): unable to SELECT values", err1)
+	}
+	return key, nil
+}
+
+func (s *DBsqlite) RevokeAPIKey(ctx context.Context, id string, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return s.errUnavailable(ctx, "DBsqlite.RevokeAPIKey(): unable to ping db", err)
+	}
+	res, err1 := s.db.ExecContext(ctx, "UPDATE apikey SET revoked = ? WHERE id = ? AND revoked = 0", now.Unix(), id)
+	if err1 != nil {
+		return s.errUnavailable(ctx, "DBsqlite.RevokeAPIKey(): unable to UPDATE values", err1)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return T.ErrNotFound
+	}
+	return nil
+}
+
+func (s *DBsqlite) ListAPIKeys(ctx context.Context) ([]T.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		return nil, s.errUnavailable(ctx, "DBsqlite.ListAPIKeys(): unable to ping db", err)
+	}
+	rows, err1 := s.db.QueryContext(ctx, "SELECT id, name, hash, tenant, admin, created, revoked FROM apikey ORDER BY created, id")
+	if err1 != nil {
+		return nil, s.errUnavailable(ctx, "DBsqlite.ListAPIKeys(): unable to SELECT values", err1)
+	}
+	defer rows.Close()
+	keys := []T.APIKey{}
+	for rows.Next() {
+		key, err2 := scanAPIKey(rows.Scan)
+		if err2 != nil {
+			return nil, s.errUnavailable(ctx, "DBsqlite.ListAPIKeys(): unable to scan values", err2)
+		}
+		keys = append(keys, key)
+	}
+	if err3 := rows.Err(); err3 != nil {
+		return nil, s.errUnavailable(ctx, "DBsqlite.ListAPIKeys(): rows error", err3)
+	}
+	return keys, nil
+}
+
+func scanAPIKey(scan func(dest ...any) error) (T.APIKey, error) {
+	var key T.APIKey
+	var created, revoked int64
+	if err := scan(&(key.ID), &(key.Name), &(key.Hash), &(key.Tenant), &(key.Admin), &created, &revoked); err != nil {
+		return T.APIKey{}, err
+	}
+	key.Created = time.Unix(created, 0)
+	if revoked != 0 {
+		key.Revoked = time.Unix(revoked, 0)
+	}
+	return key, nil
+}
+
+// groupClicks counts clicks of the hash grouped by expr, expr is never a user input
+func (s *DBsqlite) groupClicks(ctx context.Context, expr, hash string) (map[string]int64, error) {
+	res := make(map[string]int64)
+	rows, err1 := s.db.QueryContext(ctx, "SELECT "+expr+", COUNT(*) FROM click WHERE hash = ? GROUP BY 1", hash)
+	if err1 != nil {
+		return res, s.errUnavailable(ctx, "DBsqlite.groupClicks(): unable to SELECT values", err1)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var cnt int64
+		if err2 := rows.Scan(&key, &cnt); err2 != nil {
+			return res, s.errUnavailable(ctx, "DBsqlite.groupClicks(): unable to scan values", err2)
+		}
+		res[key] = cnt
+	}
+	if err3 := rows.Err(); err3 != nil {
+		return res, s.errUnavailable(ctx, "DBsqlite.groupClicks(): rows error", err3)
+	}
+	return res, nil
+}
+
+// Ping does not log failures, probes ask for them every few seconds and report them on their own
+func (s *DBsqlite) Ping(ctx context.Context) error {
+	if s.db == nil {
+		return fmt.Errorf("%w: %s", T.ErrUnavailable, "db is not connected")
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var one int
+	if err := s.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("%w: %w", T.ErrUnavailable, err)
+	}
+	return nil
+}
+
+// errUnavailable logs the driver error and marks it with T.ErrUnavailable for upper layers,
+// requests canceled by client or shutdown are not errors of db
+func (s *DBsqlite) errUnavailable(ctx context.Context, msg string, err error) error {
+	err = fmt.Errorf("%s: %w", msg, err)
+	if errors.Is(err, context.Canceled) {
+		s.log.Ctx(ctx).LogDebug("%s", err.Error())
+	} else {
+		s.log.Ctx(ctx).LogError(err)
+	}
+	return fmt.Errorf("%w: %w", T.ErrUnavailable, err)
+}
+
+// InitDB migrates the schema, the error is a schema the app can not run on;
+// a db which can not be opened is only logged, readiness reports it
+func (s *DBsqlite) InitDB() error {
+	if err := s.db.Ping(); err != nil {
+		s.log.LogError(fmt.Errorf("DBsqlite.InitDB(): unable to open db %s, set %s to a writable file: %w", s.dbpath, T.SL_DB_PATH, err))
+		return nil
+	}
+	var mode string
+	if err := s.db.QueryRow("PRAGMA journal_mode").Scan(&mode); err == nil {
+		s.log.LogInfo("DBsqlite %s, journal_mode %s", s.dbpath, mode)
+	}
+	if err1 := s.migrateOnConnect(); err1 != nil {
+		return fmt.Errorf("%s: %w", "DBsqlite.InitDB(): unable to migrate schema", err1)
+	}
+	return nil
+}
+
+func (s *DBsqlite) ConnectDB() (func(e error), error) {
+	if s.dsnErr != nil {
+		return func(e error) {}, fmt.Errorf("%s: %w", "DBsqlite.ConnectDB(): bad db config", s.dsnErr)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.dbpath), 0o750); err != nil {
+		s.log.LogError(fmt.Errorf("DBsqlite.ConnectDB(): unable to make db dir, set %s to a writable place: %w", T.SL_DB_PATH, err))
+	}
+	db, err := sql.Open("sqlite3", s.dsn)
+	if err != nil {
+		return func(e error) {}, fmt.Errorf("%s: %w", "DBsqlite.ConnectDB(): unable to connect", err)
 	}
 	s.db = db
-	s.InitDB()
+	if err1 := s.InitDB(); err1 != nil {
+		db.Close()
+		s.db = nil
+		return func(e error) {}, err1
+	}
 	s.log.LogInfo("DBsqlite connected")
 	return func(e error) {
 		if err := s.db.Close(); err != nil {
@@ -102,7 +526,14 @@ func (s *DBsqlite) ConnectDB() func(e error) {
 		}
 		s.db = nil
 		s.log.LogInfo("DBsqlite disconnected")
+	}, nil
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
 	}
+	return t.Unix()
 }
 
 /* rows, err1 := s.db.Query("SELECT hash, link FROM shortlink WHERE hash = ?", hash)
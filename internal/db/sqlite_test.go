@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	T "shortlink2/internal/types"
+	"testing"
+	"time"
+)
+
+// pairsOf gives the pairs one by one and io.EOF after them, as link file readers do
+func pairsOf(pairs ...T.DBMess) func() (T.DBMess, error) {
+	return func() (T.DBMess, error) {
+		if len(pairs) == 0 {
+			return T.DBMess{}, io.EOF
+		}
+		pair := pairs[0]
+		pairs = pairs[1:]
+		return pair, nil
+	}
+}
+
+func migratedTestDB(t *testing.T) *DBsqlite {
+	s := openTestDB(t, testCfg(t))
+	if _, err := s.Migrate(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSaveLinkPairs(t *testing.T) {
+	ctx := context.Background()
+	s := migratedTestDB(t)
+	if err := s.SaveLinkPair(ctx, T.DBMess{Hash: "a1", Link: "http://a"}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.SaveLinkPairs(ctx, pairsOf(T.DBMess{Hash: "b2", Link: "http://b"}, T.DBMess{Hash: "a1", Link: "http://x"}))
+	if !errors.Is(err, T.ErrConflict) || (n != 0) {
+		t.Fatalf("taken hash: %d saved, %v", n, err)
+	}
+	if _, err := s.LoadLinkPair(ctx, "b2"); !errors.Is(err, T.ErrNotFound) {
+		t.Fatalf("b2 is saved by the failed transaction: %v", err)
+	}
+	n, err = s.SaveLinkPairs(ctx, pairsOf(T.DBMess{Hash: "b2", Link: "http://b"}, T.DBMess{Hash: "c3", Link: ""}))
+	if !errors.Is(err, T.ErrInvalidLink) || (n != 0) {
+		t.Fatalf("empty link: %d saved, %v", n, err)
+	}
+	n, err = s.SaveLinkPairs(ctx, pairsOf(T.DBMess{Hash: "b2", Link: "http://b"}, T.DBMess{Hash: "c3", Link: "http://c"}))
+	if (err != nil) || (n != 2) {
+		t.Fatalf("free hashes: %d saved, %v", n, err)
+	}
+	if pair, err := s.LoadLinkPair(ctx, "c3"); (err != nil) || (pair.Link != "http://c") {
+		t.Fatalf("c3: %+v, %v", pair, err)
+	}
+}
+
+func TestReplaceLinkPairResetsClicks(t *testing.T) {
+	ctx := context.Background()
+	s := migratedTestDB(t)
+	if err := s.SaveLinkPair(ctx, T.DBMess{Hash: "a1", Link: "http://a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveClicks(ctx, []T.DBClick{{Hash: "a1", Time: time.Now(), Referer: "direct", Agent: "curl"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReplaceLinkPair(ctx, T.DBMess{Hash: "a1", Link: "http://b"}); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := s.LoadLinkStats(ctx, "a1")
+	if (err != nil) || (stats.Clicks != 0) {
+		t.Fatalf("clicks after overwrite: %d, %v", stats.Clicks, err)
+	}
+	if pair, _ := s.LoadLinkPair(ctx, "a1"); pair.Link != "http://b" {
+		t.Fatalf("link after overwrite: %q", pair.Link)
+	}
+	// a failing click delete must keep the old link, the trigger fails the second statement
+	if err := s.SaveClicks(ctx, []T.DBClick{{Hash: "a1", Time: time.Now(), Referer: "direct", Agent: "curl"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("CREATE TRIGGER keep BEFORE DELETE ON click BEGIN SELECT RAISE(ABORT, 'kept'); END"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReplaceLinkPair(ctx, T.DBMess{Hash: "a1", Link: "http://c"}); err == nil {
+		t.Fatal("overwrite succeeds while clicks are kept")
+	}
+	if pair, _ := s.LoadLinkPair(ctx, "a1"); pair.Link != "http://b" {
+		t.Fatalf("the link is overwritten without deleting its clicks: %q", pair.Link)
+	}
+}
+
+func TestDeleteLinkPairClicks(t *testing.T) {
+	ctx := context.Background()
+	s := migratedTestDB(t)
+	if err := s.SaveLinkPair(ctx, T.DBMess{Hash: "a1", Link: "http://a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveClicks(ctx, []T.DBClick{{Hash: "a1", Time: time.Now(), Referer: "direct", Agent: "curl"}}); err != nil {
+		t.Fatal(err)
+	}
+	// a failing click delete must keep the link, the trigger fails the second statement
+	if _, err := s.db.Exec("CREATE TRIGGER keep BEFORE DELETE ON click BEGIN SELECT RAISE(ABORT, 'kept'); END"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteLinkPair(ctx, "a1"); err == nil {
+		t.Fatal("delete succeeds while clicks are kept")
+	}
+	if _, err := s.LoadLinkPair(ctx, "a1"); err != nil {
+		t.Fatalf("the link is deleted without its clicks: %v", err)
+	}
+	if _, err := s.db.Exec("DROP TRIGGER keep"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteLinkPair(ctx, "a1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveLinkPair(ctx, T.DBMess{Hash: "a1", Link: "http://b"}); err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := s.LoadLinkStats(ctx, "a1"); stats.Clicks != 0 {
+		t.Fatalf("reclaimed hash has %d clicks", stats.Clicks)
+	}
+	if err := s.DeleteLinkPair(ctx, "b2"); !errors.Is(err, T.ErrNotFound) {
+		t.Fatalf("delete of a missing hash: %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package hashgen
+
+import (
+	T "shortlink2/internal/types"
+)
+
+var _ T.IHashGen = (*HashCounter)(nil)
+
+// HashCounter gives the next counter value for every call, link and salt are ignored
+type HashCounter struct {
+	alphabet string
+	length   int
+	cnt      *counter
+}
+
+func NewHashCounter(alphabet string, length int, store T.ICounterStore, log T.ILog) *HashCounter {
+	h := &HashCounter{
+		alphabet: alphabet,
+		length:   length,
+		cnt:      newCounter("counter", store, log),
+	}
+	return h
+}
+
+func (h *HashCounter) Hash(link string, salt int) string {
+	return encode(h.cnt.Next(), h.alphabet, h.length)
+}
+
+func (h *HashCounter) Pattern() string {
+	return pattern(h.alphabet, h.length)
+}
@@ -0,0 +1,33 @@
+package hashgen
+
+import (
+	"hash/crc32"
+	T "shortlink2/internal/types"
+	"strconv"
+)
+
+var _ T.IHashGen = (*HashCRC32)(nil)
+
+// HashCRC32 is deterministic: the same link always gives the same hash (with salt 0)
+type HashCRC32 struct {
+	alphabet string
+	length   int
+}
+
+func NewHashCRC32(alphabet string, length int) *HashCRC32 {
+	return &HashCRC32{
+		alphabet: alphabet,
+		length:   length,
+	}
+}
+
+func (h *HashCRC32) Hash(link string, salt int) string {
+	if salt != 0 {
+		link = link + "#" + strconv.Itoa(salt)
+	}
+	return encode(uint64(crc32.ChecksumIEEE([]byte(link))), h.alphabet, h.length)
+}
+
+func (h *HashCRC32) Pattern() string {
+	return pattern(h.alphabet, h.length)
+}
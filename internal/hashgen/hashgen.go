@@ -0,0 +1,126 @@
+package hashgen
+
+import (
+	"context"
+	"fmt"
+	T "shortlink2/internal/types"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Base36 = "0123456789abcdefghijklmnopqrstuvwxyz"
+	Base62 = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+	defaultLen = 6
+	minLen     = 4
+	maxLen     = 32
+)
+
+// NewHashGen returns the generator chosen by SL_HASH_GEN, crc32 is the default; counter generators
+// keep their state in the store
+func NewHashGen(cfg T.ICfg, log T.ILog, store T.ICounterStore) T.IHashGen {
+	length, err := strconv.Atoi(cfg.GetVal(T.SL_HASH_LEN))
+	if (err != nil) || (length < minLen) || (length > maxLen) {
+		log.LogError(fmt.Errorf("%s: %s=%s", "NewHashGen(): hash length must be in range 4..32, using 6", T.SL_HASH_LEN, cfg.GetVal(T.SL_HASH_LEN)))
+		length = defaultLen
+	}
+	alphabet := cfg.GetVal(T.SL_HASH_ALPHABET)
+	if len(alphabet) != 0 {
+		if err := CheckAlphabet(alphabet); err != nil {
+			log.LogError(fmt.Errorf("%s: %w", "NewHashGen(): bad alphabet, using generator default", err))
+			alphabet = ""
+		}
+	}
+	orDefault := func(def string) string {
+		if len(alphabet) == 0 {
+			return def
+		}
+		return alphabet
+	}
+	switch name := cfg.GetVal(T.SL_HASH_GEN); name {
+	case "counter":
+		return NewHashCounter(orDefault(Base62), length, store, log)
+	case "random":
+		return NewHashRandom(orDefault(Base62), length)
+	case "hashids":
+		return NewHashIDs(orDefault(Base62), length, cfg.GetVal(T.SL_HASH_SALT), store, log)
+	case "crc32":
+		return NewHashCRC32(orDefault(Base36), length)
+	default:
+		log.LogError(fmt.Errorf("%s: %s=%s", "NewHashGen(): unknown hash generator, using crc32", T.SL_HASH_GEN, name))
+		return NewHashCRC32(orDefault(Base36), length)
+	}
+}
+
+// CheckAlphabet allows only unique path-safe chars, '.' is excluded to keep away from static file names;
+// alias charsets are checked by it too
+func CheckAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return fmt.Errorf("%s: %s", "alphabet must have at least 2 chars", alphabet)
+	}
+	for i := 0; i < len(alphabet); i++ {
+		c := alphabet[i]
+		if !(('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || (c == '_') || (c == '-')) {
+			return fmt.Errorf("%s: %q", "alphabet char is not allowed", c)
+		}
+		if strings.IndexByte(alphabet[i+1:], c) >= 0 {
+			return fmt.Errorf("%s: %q", "alphabet char is duplicated", c)
+		}
+	}
+	return nil
+}
+
+// encode writes n with alphabet digits, left padded with the zero digit and cut to the last length chars
+func encode(n uint64, alphabet string, length int) string {
+	radix := uint64(len(alphabet))
+	buf := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		buf[i] = alphabet[n%radix]
+		n /= radix
+	}
+	return string(buf)
+}
+
+func pattern(alphabet string, length int) string {
+	return fmt.Sprintf("[%s]{%d}", strings.ReplaceAll(alphabet, "-", `\-`), length)
+}
+
+// counterBlock values are reserved in the store at once, values left at shutdown are skipped
+const counterBlock = 100
+
+// counter gives increasing values never given before, by blocks reserved in the store; without
+// store, or while it fails, values go on in memory from the unix time of the first one
+type counter struct {
+	name  string
+	store T.ICounterStore
+	log   T.ILog
+	mu    sync.Mutex
+	next  uint64
+	end   uint64 // the block is used up when next reaches it
+}
+
+func newCounter(name string, store T.ICounterStore, log T.ILog) *counter {
+	return &counter{name: name, store: store, log: log}
+}
+
+func (c *counter) Next() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if (c.next >= c.end) && (c.store != nil) {
+		first, err := c.store.ReserveCounter(context.Background(), c.name, counterBlock)
+		if err == nil {
+			c.next, c.end = max(first, c.next), first+counterBlock
+		} else {
+			c.log.LogError(fmt.Errorf("counter.Next(): unable to reserve %s values, counting in memory: %w", c.name, err))
+		}
+	}
+	if c.next == 0 {
+		c.next = uint64(time.Now().Unix())
+	}
+	n := c.next
+	c.next++
+	return n
+}
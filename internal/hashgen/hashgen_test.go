@@ -0,0 +1,144 @@
+package hashgen
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	T "shortlink2/internal/types"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type cfgMap map[string]string
+
+func (c cfgMap) GetVal(key string) string { return c[key] }
+func (c cfgMap) Parse() T.ICfg            { return c }
+func (c cfgMap) Validate() error          { return nil }
+
+type nolog struct{ T.ILog }
+
+func (nolog) LogError(err error) {}
+
+// store reserves counter values like the db, failing while down
+type store struct {
+	next  map[string]uint64
+	calls int
+	down  bool
+}
+
+func (s *store) ReserveCounter(ctx context.Context, name string, n uint64) (uint64, error) {
+	if s.down {
+		return 0, errors.New("db is down")
+	}
+	s.calls++
+	first := max(s.next[name], 1)
+	s.next[name] = first + n
+	return first, nil
+}
+
+func newStore() *store { return &store{next: map[string]uint64{}} }
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		gen      string
+		alphabet string
+		length   int
+		want     string // alphabet of hashes
+		distinct bool   // every call gives a new hash for the same link
+	}{
+		{"crc32", "", 6, Base36, false},
+		{"counter", "", 8, Base62, true},
+		{"random", "abc", 10, "abc", true},
+		{"hashids", "", 7, Base62, true},
+		{"unknown", "", 6, Base36, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.gen, func(t *testing.T) {
+			cfg := cfgMap{T.SL_HASH_GEN: tt.gen, T.SL_HASH_LEN: strconv.Itoa(tt.length), T.SL_HASH_ALPHABET: tt.alphabet, T.SL_HASH_SALT: "pepper"}
+			gen := NewHashGen(cfg, nolog{}, newStore())
+			re := regexp.MustCompile("^" + gen.Pattern() + "$")
+			seen := map[string]bool{}
+			for i := 0; i < 300; i++ {
+				hash := gen.Hash("http://lib.ru", 0)
+				if (len(hash) != tt.length) || !re.MatchString(hash) || (strings.Trim(hash, tt.want) != "") {
+					t.Fatalf("hash %q of length %d, pattern %s, alphabet %s", hash, len(hash), gen.Pattern(), tt.want)
+				}
+				seen[hash] = true
+			}
+			if tt.distinct && (len(seen) != 300) {
+				t.Fatalf("%d distinct hashes of 300", len(seen))
+			}
+			if !tt.distinct && (len(seen) != 1) {
+				t.Fatalf("deterministic generator gave %d hashes", len(seen))
+			}
+		})
+	}
+}
+
+func TestCRC32Salt(t *testing.T) {
+	gen := NewHashCRC32(Base36, 6)
+	if gen.Hash("http://lib.ru", 0) == gen.Hash("http://lib.ru", 1) {
+		t.Fatal("salt does not change the hash")
+	}
+}
+
+func TestNewHashGenFallbacks(t *testing.T) {
+	for _, cfg := range []cfgMap{
+		{T.SL_HASH_GEN: "crc32", T.SL_HASH_LEN: "3"},
+		{T.SL_HASH_GEN: "crc32", T.SL_HASH_LEN: "33"},
+		{T.SL_HASH_GEN: "crc32", T.SL_HASH_LEN: "six"},
+		{T.SL_HASH_GEN: "crc32", T.SL_HASH_LEN: "6", T.SL_HASH_ALPHABET: "ab.c"},
+		{T.SL_HASH_GEN: "crc32", T.SL_HASH_LEN: "6", T.SL_HASH_ALPHABET: "abca"},
+	} {
+		hash := NewHashGen(cfg, nolog{}, nil).Hash("http://lib.ru", 0)
+		if (len(hash) != defaultLen) || (strings.Trim(hash, Base36) != "") {
+			t.Fatalf("%v gives %q, want the default length and alphabet", cfg, hash)
+		}
+	}
+}
+
+func TestCounterReserve(t *testing.T) {
+	st := newStore()
+	c := newCounter("counter", st, nolog{})
+	prev := uint64(0)
+	for i := 0; i < 2*counterBlock+1; i++ {
+		n := c.Next()
+		if n <= prev {
+			t.Fatalf("value %d after %d", n, prev)
+		}
+		prev = n
+	}
+	if st.calls != 3 {
+		t.Fatalf("%d reservations for %d values, want 3", st.calls, 2*counterBlock+1)
+	}
+	// a restart skips the rest of the block, values are never given twice
+	if n := newCounter("counter", st, nolog{}).Next(); n <= prev {
+		t.Fatalf("value %d after restart, the last one was %d", n, prev)
+	}
+	if n := newCounter("hashids", st, nolog{}).Next(); n != 1 {
+		t.Fatalf("counters share values: %d", n)
+	}
+}
+
+func TestCounterStoreDown(t *testing.T) {
+	st := newStore()
+	st.down = true
+	c := newCounter("counter", st, nolog{})
+	now := uint64(time.Now().Unix())
+	first := c.Next()
+	if (first < now) || (first > now+1) {
+		t.Fatalf("value %d while the store is down, want the unix time %d", first, now)
+	}
+	if n := c.Next(); n != first+1 {
+		t.Fatalf("value %d after %d", n, first)
+	}
+	// the store is back with values below the time seeded ones, they must not go back
+	st.down = false
+	for i := 0; i < counterBlock; i++ {
+		if n := c.Next(); n <= first+1 {
+			t.Fatalf("value %d after the store is back, the last one was %d", n, first+1)
+		}
+	}
+}
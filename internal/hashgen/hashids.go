@@ -0,0 +1,54 @@
+package hashgen
+
+import (
+	T "shortlink2/internal/types"
+)
+
+var _ T.IHashGen = (*HashIDs)(nil)
+
+// HashIDs is a hashids-like obfuscated counter: the first char is a lottery picked by the
+// counter value, the rest is the counter encoded with the alphabet shuffled by lottery and salt
+type HashIDs struct {
+	alphabet string
+	length   int
+	salt     string
+	cnt      *counter
+}
+
+func NewHashIDs(alphabet string, length int, salt string, store T.ICounterStore, log T.ILog) *HashIDs {
+	h := &HashIDs{
+		alphabet: shuffle(alphabet, salt),
+		length:   length,
+		salt:     salt,
+		cnt:      newCounter("hashids", store, log),
+	}
+	return h
+}
+
+func (h *HashIDs) Hash(link string, salt int) string {
+	n := h.cnt.Next()
+	lottery := h.alphabet[n%uint64(len(h.alphabet))]
+	alphabet := shuffle(h.alphabet, string(lottery)+h.salt)
+	return string(lottery) + encode(n, alphabet, h.length-1)
+}
+
+func (h *HashIDs) Pattern() string {
+	return pattern(h.alphabet, h.length)
+}
+
+// shuffle is the hashids consistent shuffle: the same alphabet and salt always give the same order
+func shuffle(alphabet, salt string) string {
+	if len(salt) == 0 {
+		return alphabet
+	}
+	res := []byte(alphabet)
+	for i, v, p := len(res)-1, 0, 0; i > 0; i-- {
+		v %= len(salt)
+		n := int(salt[v])
+		p += n
+		j := (n + v + p) % i
+		res[i], res[j] = res[j], res[i]
+		v++
+	}
+	return string(res)
+}
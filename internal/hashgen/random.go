@@ -0,0 +1,39 @@
+package hashgen
+
+import (
+	"crypto/rand"
+	"math/big"
+	T "shortlink2/internal/types"
+)
+
+var _ T.IHashGen = (*HashRandom)(nil)
+
+// HashRandom gives crypto-safe random hashes, link and salt are ignored
+type HashRandom struct {
+	alphabet string
+	length   int
+}
+
+func NewHashRandom(alphabet string, length int) *HashRandom {
+	return &HashRandom{
+		alphabet: alphabet,
+		length:   length,
+	}
+}
+
+func (h *HashRandom) Hash(link string, salt int) string {
+	radix := big.NewInt(int64(len(h.alphabet)))
+	buf := make([]byte, h.length)
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, radix)
+		if err != nil {
+			return ""
+		}
+		buf[i] = h.alphabet[n.Int64()]
+	}
+	return string(buf)
+}
+
+func (h *HashRandom) Pattern() string {
+	return pattern(h.alphabet, h.length)
+}
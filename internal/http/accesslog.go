@@ -0,0 +1,109 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	G "shortlink2/internal/hashgen"
+	R "shortlink2/internal/http/route"
+	T "shortlink2/internal/types"
+	"time"
+)
+
+/*
+	One INFO line per request with its own JSON keys, duration is in seconds. The request id is
+	taken from X-Request-ID of the client or proxy if it looks safe, otherwise a new one is made.
+	It is sent back in X-Request-ID and goes as "R" to the access line and every log line of
+	service and db layers made by log.Ctx(ctx) during the request:
+
+	{"T":"...","L":"INFO","H":"...","S":"shortlink2","R":"Xr4...","M":"access","method":"GET","path":"/5clp60","status":302,
+	"bytes":0,"duration":0.0012,"remote":"127.0.0.1:5321","agent":"curl/8.5.0","hash":"5clp60"}
+*/
+
+const requestIDMax = 64
+
+var requestIDs = G.NewHashRandom(G.Base62, 20)
+
+// accessWriter counts status and body bytes for the access log
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (aw *accessWriter) WriteHeader(status int) {
+	if aw.status == 0 {
+		aw.status = status
+	}
+	aw.ResponseWriter.WriteHeader(status)
+}
+
+func (aw *accessWriter) Write(b []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	n, err := aw.ResponseWriter.Write(b)
+	aw.bytes += n
+	return n, err
+}
+
+func (aw *accessWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
+
+// accessRecord is filled by handlers with the hash they resolved
+type accessRecord struct {
+	hash string
+}
+
+type accessCtx struct{}
+
+// logHash marks the request with the hash it works on, for the access log line
+func logHash(r *http.Request, hash string) {
+	if rec, ok := r.Context().Value(accessCtx{}).(*accessRecord); ok {
+		rec.hash = hash
+	}
+}
+
+// validRequestID lets ids of clients and proxies into the log only if they are short and plain
+func validRequestID(id string) bool {
+	if (len(id) == 0) || (len(id) > requestIDMax) {
+		return false
+	}
+	for _, c := range id {
+		if !(((c >= '0') && (c <= '9')) || ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z')) || (c == '-') || (c == '_') || (c == '.')) {
+			return false
+		}
+	}
+	return true
+}
+
+// accessLog is the outermost global middleware, so it sees statuses of auth, rate limits and panics
+func (hns *HTTPServerNet) accessLog() R.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rid := r.Header.Get("X-Request-ID")
+			if !validRequestID(rid) {
+				rid = requestIDs.Hash("", 0)
+			}
+			w.Header().Set("X-Request-ID", rid)
+			rec := &accessRecord{}
+			ctx := context.WithValue(T.WithRequestID(r.Context(), rid), accessCtx{}, rec)
+			aw := &accessWriter{ResponseWriter: w}
+			next.ServeHTTP(aw, r.WithContext(ctx))
+			if aw.status == 0 {
+				aw.status = http.StatusOK
+			}
+			hns.log.Ctx(ctx).LogInfoFields("access",
+				T.LogField{Key: "method", Val: r.Method},
+				T.LogField{Key: "path", Val: r.URL.RequestURI()},
+				T.LogField{Key: "status", Val: aw.status},
+				T.LogField{Key: "bytes", Val: aw.bytes},
+				T.LogField{Key: "duration", Val: time.Since(start).Seconds()},
+				T.LogField{Key: "remote", Val: r.RemoteAddr},
+				T.LogField{Key: "agent", Val: r.UserAgent()},
+				T.LogField{Key: "hash", Val: rec.hash},
+			)
+		})
+	}
+}
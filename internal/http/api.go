@@ -0,0 +1,177 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	R "shortlink2/internal/http/route"
+	T "shortlink2/internal/types"
+	"strconv"
+	"time"
+)
+
+/*
+	REST API v1, built on the same service as legacy POST /load, /save, /delete:
+
+	curl -i -X POST localhost:8080/api/v1/links -d '{"link":"http://lib.ru","ttl":"24h"}'
+	curl -i localhost:8080/api/v1/links/5clp60
+	curl -i -X PATCH localhost:8080/api/v1/links/5clp60 -d '{"link":"http://lib.ru/PROZA/"}'
+	curl -i -X PATCH localhost:8080/api/v1/links/5clp60 -d '{"ttl":"72h"}'
+	curl -i -X DELETE localhost:8080/api/v1/links/5clp60
+	curl -i 'localhost:8080/api/v1/links?limit=10&cursor=5clp60'
+*/
+
+const (
+	apiLinks    = "/api/v1/links"
+	apiPageSize = 100
+	apiPageMax  = 1000
+)
+
+// apiError writes the error envelope, details of server side errors stay in the log
+func (hns *HTTPServerNet) apiError(w http.ResponseWriter, err error) {
+	status := errStatus(err)
+	mess := err.Error()
+	if status >= http.StatusInternalServerError {
+		mess = http.StatusText(status)
+	}
+	hns.writeJSON(w, status, T.APIError{Error: T.APIErrorBody{Status: status, Message: mess}})
+}
+
+func (hns *HTTPServerNet) apiBadRequest(w http.ResponseWriter, err error) {
+	hns.writeJSON(w, http.StatusBadRequest, T.APIError{Error: T.APIErrorBody{Status: http.StatusBadRequest, Message: err.Error()}})
+}
+
+func (hns *HTTPServerNet) apiCreateLink(w http.ResponseWriter, r *http.Request) {
+	req := T.APILinkReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		hns.apiBadRequest(w, err)
+		return
+	}
+	expire, err := parseExpire(req.TTL, req.Expire)
+	if err != nil {
+		hns.apiBadRequest(w, err)
+		return
+	}
+	var hash string
+	if len(req.Alias) != 0 {
+		hash, err = hns.svc.SetLinkAlias(r.Context(), req.Alias, req.Link, expire)
+	} else {
+		hash, err = hns.svc.SetLinkPair(r.Context(), req.Link, expire)
+	}
+	if err != nil {
+		hns.apiError(w, err)
+		return
+	}
+	logHash(r, hash)
+	hns.mtr.saves.Inc()
+	pair, err := hns.svc.GetLinkInfo(r.Context(), hash)
+	if err != nil {
+		hns.apiError(w, err)
+		return
+	}
+	w.Header().Set("Location", apiLinks+"/"+hash)
+	hns.writeJSON(w, http.StatusCreated, T.NewAPILink(pair))
+}
+
+// apiListLinks pages links of the key tenant, admins may ask for any ?tenant=
+func (hns *HTTPServerNet) apiListLinks(w http.ResponseWriter, r *http.Request) {
+	key, _ := T.APIKeyFrom(r.Context())
+	query := r.URL.Query()
+	tenant := key.Tenant
+	if query.Has("tenant") {
+		tenant = query.Get("tenant")
+	}
+	limit := apiPageSize
+	if str := query.Get("limit"); len(str) != 0 {
+		n, err := strconv.Atoi(str)
+		if (err != nil) || (n < 1) || (n > apiPageMax) {
+			hns.apiBadRequest(w, fmt.Errorf("limit must be in range 1..%d", apiPageMax))
+			return
+		}
+		limit = n
+	}
+	pairs, err := hns.svc.ListLinks(r.Context(), tenant, query.Get("cursor"), limit)
+	if err != nil {
+		hns.apiError(w, err)
+		return
+	}
+	list := T.APILinkList{Links: make([]T.APILink, 0, len(pairs))}
+	for _, pair := range pairs {
+		list.Links = append(list.Links, T.NewAPILink(pair))
+	}
+	if len(pairs) == limit {
+		list.Next = pairs[len(pairs)-1].Hash
+	}
+	hns.writeJSON(w, http.StatusOK, list)
+}
+
+func (hns *HTTPServerNet) apiGetLink(w http.ResponseWriter, r *http.Request) {
+	hash := R.Param(r, "hash")
+	logHash(r, hash)
+	pair, err := hns.svc.GetLinkInfo(r.Context(), hash)
+	if err != nil {
+		hns.apiError(w, err)
+		return
+	}
+	hns.writeJSON(w, http.StatusOK, T.NewAPILink(pair))
+}
+
+// apiUpdateLink changes the link and/or its expire time of ttl or expire, the hash stays the same
+func (hns *HTTPServerNet) apiUpdateLink(w http.ResponseWriter, r *http.Request) {
+	hash := R.Param(r, "hash")
+	logHash(r, hash)
+	req := T.APILinkReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		hns.apiBadRequest(w, err)
+		return
+	}
+	if len(req.Alias) != 0 {
+		hns.apiBadRequest(w, fmt.Errorf("%s", "alias is the hash of the link, it can not be changed"))
+		return
+	}
+	var expire *time.Time
+	if (len(req.TTL) != 0) || (len(req.Expire) != 0) {
+		t, err := parseExpire(req.TTL, req.Expire)
+		if err != nil {
+			hns.apiBadRequest(w, err)
+			return
+		}
+		expire = &t
+	}
+	if (len(req.Link) == 0) && (expire == nil) {
+		hns.apiBadRequest(w, fmt.Errorf("%s", "nothing to change, pass link, ttl or expire"))
+		return
+	}
+	if err := hns.svc.UpdLinkPair(r.Context(), hash, req.Link, expire); err != nil {
+		hns.apiError(w, err)
+		return
+	}
+	pair, err := hns.svc.GetLinkInfo(r.Context(), hash)
+	if err != nil {
+		hns.apiError(w, err)
+		return
+	}
+	hns.writeJSON(w, http.StatusOK, T.NewAPILink(pair))
+}
+
+func (hns *HTTPServerNet) apiDeleteLink(w http.ResponseWriter, r *http.Request) {
+	hash := R.Param(r, "hash")
+	logHash(r, hash)
+	if err := hns.svc.DelLinkPair(r.Context(), hash); err != nil {
+		hns.apiError(w, err)
+		return
+	}
+	hns.mtr.deletes.Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (hns *HTTPServerNet) apiGetStats(w http.ResponseWriter, r *http.Request) {
+	hash := R.Param(r, "hash")
+	logHash(r, hash)
+	stats, err := hns.svc.GetLinkStats(r.Context(), hash)
+	if err != nil {
+		hns.apiError(w, err)
+		return
+	}
+	hns.writeJSON(w, http.StatusOK, stats)
+}
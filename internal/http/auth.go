@@ -0,0 +1,62 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	R "shortlink2/internal/http/route"
+	T "shortlink2/internal/types"
+	"strings"
+)
+
+/*
+	Mutating and admin routes need "Authorization: Bearer sl2_<id>_<secret>", keys are made by CLI:
+
+	shortlink2 key create [-admin] [-tenant <tenant>] <name>
+	curl -i -X POST localhost:8080/save -H 'Authorization: Bearer sl2_...' -d '{"M":"save","H":"","L":"http://lib.ru"}'
+*/
+
+type authLevel int8
+
+const (
+	authSave  authLevel = iota // any key, or nobody if SL_AUTH_ANONSAVE=true
+	authKey                    // any key
+	authAdmin                  // admin key
+)
+
+// authorize puts the key of the request to its context, anonymous save requests pass with no key
+func (hns *HTTPServerNet) authorize(r *http.Request, level authLevel) (*http.Request, error) {
+	header := r.Header.Get("Authorization")
+	if (len(header) == 0) && (level == authSave) && hns.auth.AnonSave() {
+		return r, nil
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return r, fmt.Errorf("%w: %s", T.ErrUnauthorized, "bearer API key required")
+	}
+	key, err := hns.auth.Authenticate(r.Context(), strings.TrimSpace(token))
+	if err != nil {
+		return r, err
+	}
+	if (level == authAdmin) && !key.Admin {
+		return r, fmt.Errorf("%w: %s", T.ErrForbidden, "admin API key required")
+	}
+	return r.WithContext(T.WithAPIKey(r.Context(), key)), nil
+}
+
+// requireKey is the auth middleware, fail writes the error in the format of the route
+func (hns *HTTPServerNet) requireKey(level authLevel, fail func(w http.ResponseWriter, err error)) R.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, err := hns.authorize(r, level)
+			if err != nil {
+				if errors.Is(err, T.ErrUnauthorized) {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="shortlink2"`)
+				}
+				fail(w, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
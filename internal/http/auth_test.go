@@ -0,0 +1,152 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	D "shortlink2/internal/db"
+	G "shortlink2/internal/hashgen"
+	L "shortlink2/internal/log"
+	M "shortlink2/internal/metrics"
+	P "shortlink2/internal/policy"
+	S "shortlink2/internal/service"
+	T "shortlink2/internal/types"
+	"strings"
+	"testing"
+)
+
+// testAPI serves the API over a mock db, tokens are of keys: owner and other without tenant,
+// acme and beta of their tenants, admin and revoked
+func testAPI(t *testing.T) (http.Handler, map[string]string) {
+	cfg := cfgMap{
+		T.SL_LOG_LEVEL: "NOLOG", T.SL_HASH_GEN: "crc32", T.SL_HASH_LEN: "6", T.SL_ALIAS_CHARSET: "0123456789abcdefghijklmnopqrstuvwxyz-_",
+		T.SL_ALIAS_MINLEN: "4", T.SL_ALIAS_MAXLEN: "32", T.SL_LINK_SCHEMES: "http,https", T.SL_LINK_MAXLEN: "2048", T.SL_LINK_FRAGMENT: "keep",
+		T.SL_REAP_PERIOD: "0", T.SL_POLICY_RELOAD: "0", T.SL_POLICY_PRIVATE: "deny", T.SL_AUTH_ANONSAVE: "false",
+		T.SL_RATE_REDIRECT: "0", T.SL_RATE_SAVE: "0", T.SL_METRICS: "off",
+	}
+	log := L.NewLogFprintf(cfg, 0)
+	db := D.NewDBmock(cfg, log)
+	svc := S.NewSvcShortLink2(db, G.NewHashGen(cfg, log, db), P.NewPolicyFile(cfg, log), log, cfg)
+	auth := S.NewSvcAuth(db, log, cfg)
+	hns := NewHTTPServerNet(svc, auth, S.NewSvcBulk(db, log), nil, log, cfg, M.NewRegistry())
+	tokens := map[string]string{}
+	for _, k := range []struct {
+		name, tenant string
+		admin        bool
+	}{{"owner", "", false}, {"other", "", false}, {"acme", "acme", false}, {"beta", "beta", false}, {"admin", "", true}, {"revoked", "", false}} {
+		token, key, err := auth.CreateKey(context.Background(), k.name, k.tenant, k.admin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[k.name] = token
+		if k.name == "revoked" {
+			if err := auth.RevokeKey(context.Background(), key.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return hns.handlers(), tokens
+}
+
+func apiCall(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if len(token) != 0 {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthKeys(t *testing.T) {
+	h, tokens := testAPI(t)
+	tests := []struct {
+		name, token string
+		status      int
+	}{
+		{"no key", "", http.StatusUnauthorized},
+		{"malformed key", "abc", http.StatusUnauthorized},
+		{"unknown key", "sl2_abc_def", http.StatusUnauthorized},
+		{"revoked key", tokens["revoked"], http.StatusUnauthorized},
+		{"key", tokens["owner"], http.StatusCreated},
+	}
+	for _, tt := range tests {
+		w := apiCall(h, "POST", apiLinks, tt.token, `{"link":"http://lib.ru/`+strings.ReplaceAll(tt.name, " ", "-")+`"}`)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+		if (tt.status == http.StatusUnauthorized) && (len(w.Header().Get("WWW-Authenticate")) == 0) {
+			t.Errorf("%s: no WWW-Authenticate header", tt.name)
+		}
+	}
+	if w := apiCall(h, "GET", apiExport, tokens["owner"], ""); w.Code != http.StatusForbidden {
+		t.Errorf("admin route with a key of no admin: status %d", w.Code)
+	}
+}
+
+func TestAuthModify(t *testing.T) {
+	h, tokens := testAPI(t)
+	w := apiCall(h, "POST", apiLinks, tokens["owner"], `{"link":"http://lib.ru/","alias":"owned"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+	tests := []struct {
+		who, method, body string
+		status            int
+	}{
+		{"other", "PATCH", `{"link":"http://evil.com/"}`, http.StatusForbidden},
+		{"other", "DELETE", "", http.StatusForbidden},
+		{"acme", "PATCH", `{"ttl":"1h"}`, http.StatusForbidden},
+		{"revoked", "PATCH", `{"link":"http://evil.com/"}`, http.StatusUnauthorized},
+		{"owner", "PATCH", `{"link":"http://lib.ru/a"}`, http.StatusOK},
+		{"admin", "PATCH", `{"link":"http://lib.ru/b"}`, http.StatusOK},
+		{"admin", "DELETE", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if w := apiCall(h, tt.method, apiLinks+"/owned", tokens[tt.who], tt.body); w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.who, tt.method, w.Code, tt.status, w.Body.String())
+		}
+	}
+}
+
+func TestAuthListTenants(t *testing.T) {
+	h, tokens := testAPI(t)
+	for who, alias := range map[string]string{"acme": "acme-link", "beta": "beta-link", "owner": "own-link", "other": "other-link"} {
+		if w := apiCall(h, "POST", apiLinks, tokens[who], `{"link":"http://lib.ru/`+who+`","alias":"`+alias+`"}`); w.Code != http.StatusCreated {
+			t.Fatalf("create of %s: %d %s", who, w.Code, w.Body.String())
+		}
+	}
+	tests := []struct {
+		who, query string
+		status     int
+		hashes     []string
+	}{
+		{"acme", "", http.StatusOK, []string{"acme-link"}},
+		{"acme", "?tenant=beta", http.StatusForbidden, nil},
+		{"beta", "?tenant=acme", http.StatusForbidden, nil},
+		{"owner", "", http.StatusOK, []string{"own-link"}}, // keys without tenant share the tenant, not the links
+		{"owner", "?tenant=acme", http.StatusForbidden, nil},
+		{"admin", "?tenant=beta", http.StatusOK, []string{"beta-link"}},
+		{"admin", "", http.StatusOK, []string{"other-link", "own-link"}},
+	}
+	for _, tt := range tests {
+		w := apiCall(h, "GET", apiLinks+tt.query, tokens[tt.who], "")
+		if w.Code != tt.status {
+			t.Errorf("%s%s: status %d, want %d: %s", tt.who, tt.query, w.Code, tt.status, w.Body.String())
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		list := T.APILinkList{}
+		json.Unmarshal(w.Body.Bytes(), &list)
+		hashes := []string{}
+		for _, link := range list.Links {
+			hashes = append(hashes, link.Hash)
+		}
+		if strings.Join(hashes, ",") != strings.Join(tt.hashes, ",") {
+			t.Errorf("%s%s: links %v, want %v", tt.who, tt.query, hashes, tt.hashes)
+		}
+	}
+}
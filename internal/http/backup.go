@@ -0,0 +1,35 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+)
+
+/*
+	Online db snapshots, admin only, restore is a CLI command with the server stopped:
+
+	curl -s -X POST localhost:8080/api/v1/backups -H 'Authorization: Bearer sl2_...'
+	{"name":"sqlite-20261018T071000.000Z.db","size":49152,"created":"2026-10-18T07:10:00Z"}
+	curl -s localhost:8080/api/v1/backups -H 'Authorization: Bearer sl2_...'
+*/
+
+const apiBackups = "/api/v1/backups"
+
+func (hns *HTTPServerNet) apiCreateBackup(w http.ResponseWriter, r *http.Request) {
+	snap, err := hns.backup.Backup(r.Context())
+	if err != nil {
+		hns.apiError(w, err)
+		return
+	}
+	hns.writeJSON(w, http.StatusCreated, snap)
+}
+
+func (hns *HTTPServerNet) apiListBackups(w http.ResponseWriter, r *http.Request) {
+	snaps, err := hns.backup.Snapshots()
+	if err != nil {
+		hns.log.Ctx(r.Context()).LogError(fmt.Errorf("%s: %w", "HTTPServerNet.apiListBackups()", err))
+		hns.apiError(w, err)
+		return
+	}
+	hns.writeJSON(w, http.StatusOK, snaps)
+}
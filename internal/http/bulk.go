@@ -0,0 +1,100 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	F "shortlink2/internal/linkfile"
+	T "shortlink2/internal/types"
+	"time"
+)
+
+/*
+	Bulk links of all tenants, admin only:
+
+	curl -s localhost:8080/api/v1/export?format=csv -H 'Authorization: Bearer sl2_...' > links.csv
+	curl -s -X POST 'localhost:8080/api/v1/import?format=csv&conflict=skip' -H 'Authorization: Bearer sl2_...' --data-binary @links.csv
+	{"read":2,"added":1,"overwritten":0,"skipped":1,"failed":0,"done":true}
+*/
+
+const (
+	apiExport = "/api/v1/export"
+	apiImport = "/api/v1/import"
+)
+
+// bulkTimeout replaces server read and write timeouts for bulk streams
+const bulkTimeout = time.Hour
+
+var bulkTypes = map[string]string{
+	F.JSON:  "application/json",
+	F.JSONL: "application/x-ndjson",
+	F.CSV:   "text/csv; charset=utf-8",
+}
+
+// bulkParams reads ?format= (jsonl by default) and ?conflict= (skip by default)
+func bulkParams(r *http.Request) (string, string, error) {
+	query := r.URL.Query()
+	format, conflict := query.Get("format"), query.Get("conflict")
+	if len(format) == 0 {
+		format = F.JSONL
+	}
+	if len(conflict) == 0 {
+		conflict = T.ConflictSkip
+	}
+	if _, ok := bulkTypes[format]; !ok {
+		return "", "", fmt.Errorf("unknown format %q, use json, jsonl or csv", format)
+	}
+	switch conflict {
+	case T.ConflictSkip, T.ConflictOverwrite, T.ConflictFail:
+	default:
+		return "", "", fmt.Errorf("unknown conflict policy %q, use skip, overwrite or fail", conflict)
+	}
+	return format, conflict, nil
+}
+
+func (hns *HTTPServerNet) apiExportLinks(w http.ResponseWriter, r *http.Request) {
+	format, _, err := bulkParams(r)
+	if err != nil {
+		hns.apiBadRequest(w, err)
+		return
+	}
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(bulkTimeout))
+	w.Header().Set("Content-Type", bulkTypes[format])
+	w.Header().Set("Content-Disposition", `attachment; filename="links.`+format+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	log := hns.log.Ctx(r.Context())
+	report, err := hns.bulk.Export(r.Context(), w, format, func(report T.BulkReport) {
+		rc.Flush()
+		log.LogDebug("links export: %d links sent", report.Read)
+	})
+	if err != nil { // the status is already sent, the client sees a broken stream
+		log.LogError(fmt.Errorf("%s after %d links: %w", "HTTPServerNet.apiExportLinks(): export is broken", report.Read, err))
+		return
+	}
+	log.LogInfo("links export: %d links in %s", report.Read, format)
+}
+
+func (hns *HTTPServerNet) apiImportLinks(w http.ResponseWriter, r *http.Request) {
+	format, conflict, err := bulkParams(r)
+	if err != nil {
+		hns.apiBadRequest(w, err)
+		return
+	}
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(bulkTimeout))
+	rc.SetWriteDeadline(time.Now().Add(bulkTimeout))
+	log := hns.log.Ctx(r.Context())
+	report, err := hns.bulk.Import(r.Context(), r.Body, format, conflict, func(report T.BulkReport) {
+		log.LogDebug("links import: %d read, %d added, %d overwritten, %d skipped", report.Read, report.Added, report.Overwritten, report.Skipped)
+	})
+	if err != nil {
+		status := errStatus(err)
+		if status >= http.StatusInternalServerError {
+			report.Error = http.StatusText(status)
+		}
+		hns.writeJSON(w, status, report)
+		return
+	}
+	log.LogInfo("links import: %d read, %d added, %d overwritten, %d skipped", report.Read, report.Added, report.Overwritten, report.Skipped)
+	hns.writeJSON(w, http.StatusOK, report)
+}
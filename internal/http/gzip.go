@@ -0,0 +1,118 @@
+package http
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipTypes are compressed, redirects and images are passed as is
+var gzipTypes = []string{"application/json", "application/x-ndjson", "text/html", "text/plain", "text/css", "text/csv", "text/javascript"}
+
+var gzipPool = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// gzipWriter decides to compress on WriteHeader, when the content type is already known
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (gw *gzipWriter) WriteHeader(status int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	header := gw.Header()
+	header.Add("Vary", "Accept-Encoding")
+	ctype := header.Get("Content-Type")
+	if (status != http.StatusNoContent) && (status != http.StatusNotModified) && (len(header.Get("Content-Encoding")) == 0) && (len(header.Get("Content-Range")) == 0) {
+		for _, t := range gzipTypes {
+			if strings.HasPrefix(ctype, t) {
+				header.Del("Content-Length")
+				header.Set("Content-Encoding", "gzip")
+				gw.gz = gzipPool.Get().(*gzip.Writer)
+				gw.gz.Reset(gw.ResponseWriter)
+				break
+			}
+		}
+	}
+	gw.ResponseWriter.WriteHeader(status)
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	if !gw.wroteHeader {
+		if len(gw.Header().Get("Content-Type")) == 0 {
+			gw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		gw.WriteHeader(http.StatusOK)
+	}
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the connection for deadlines
+func (gw *gzipWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// Flush pushes compressed data of long streams
+func (gw *gzipWriter) Flush() {
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+func (gw *gzipWriter) close() {
+	if gw.gz != nil {
+		gw.gz.Close()
+		gzipPool.Put(gw.gz)
+	}
+}
+
+// acceptsGzip reads q-values of Accept-Encoding, gzip;q=0 refuses it even if * is accepted
+func acceptsGzip(r *http.Request) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, coding := range strings.Split(strings.Join(r.Header.Values("Accept-Encoding"), ","), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if !strings.EqualFold(key, "q") {
+				continue
+			}
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(val), 64); err != nil {
+				q = 0
+			}
+		}
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// gzipResponse is the global compression middleware for clients accepting gzip
+func gzipResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) || (r.Method == http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
@@ -0,0 +1,70 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	T "shortlink2/internal/types"
+	"time"
+)
+
+/*
+	Orchestrator probes, both are open and not rate limited:
+
+	livenessProbe:  { httpGet: { path: /healthz, port: 8080 } }
+	readinessProbe: { httpGet: { path: /readyz,  port: 8080 }, periodSeconds: 2 }
+
+	curl -i localhost:8080/readyz
+	{"status":"fail","components":{"config":{"status":"ok"},"db":{"status":"ok"},"shutdown":{"status":"fail","error":"draining"}}}
+*/
+
+// pingTimeout keeps probes fast even if SL_DB_TIMEOUT is long
+const pingTimeout = time.Second
+
+func (hns *HTTPServerNet) getHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	hns.writeJSON(w, http.StatusOK, T.Health{Status: "ok"})
+}
+
+func (hns *HTTPServerNet) getReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+	defer cancel()
+	var shutdown error
+	if hns.drain.Load() {
+		shutdown = fmt.Errorf("%s", "draining")
+	}
+	health := T.Health{Status: "ok", Components: map[string]T.HealthComponent{}}
+	for name, err := range map[string]error{
+		"db":       hns.svc.Ping(ctx),
+		"config":   hns.cfgErr,
+		"shutdown": shutdown,
+	} {
+		if err != nil {
+			health.Status = "fail"
+			health.Components[name] = T.HealthComponent{Status: "fail", Error: err.Error()}
+		} else {
+			health.Components[name] = T.HealthComponent{Status: "ok"}
+		}
+	}
+	status := http.StatusOK
+	if health.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	hns.writeJSON(w, status, health)
+}
+
+// drainTraffic fails readiness and waits SL_HTTP_DRAIN, so load balancers stop sending new requests
+// before the listener is closed
+func (hns *HTTPServerNet) drainTraffic() {
+	hns.drain.Store(true)
+	drain, err := time.ParseDuration(hns.cfg.GetVal(T.SL_HTTP_DRAIN))
+	if (err != nil) || (drain < 0) {
+		hns.log.LogError(fmt.Errorf("%s: %s=%s", "HTTPServerNet.drainTraffic(): bad drain time, using 0s", T.SL_HTTP_DRAIN, hns.cfg.GetVal(T.SL_HTTP_DRAIN)))
+		return
+	}
+	if drain != 0 {
+		hns.log.LogInfo("net/http server draining for %s", drain)
+		time.Sleep(drain)
+	}
+}
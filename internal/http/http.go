@@ -3,14 +3,18 @@ package http
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
+	"net"
 	"net/http"
 	"os"
-	"strings"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	R "shortlink2/internal/http/route"
+	M "shortlink2/internal/metrics"
 	T "shortlink2/internal/types"
 	W "shortlink2/web"
 )
@@ -18,24 +22,39 @@ import (
 var _ T.IHTTPServer = (*HTTPServerNet)(nil)
 
 type HTTPServerNet struct {
-	hsrv *http.Server
-	svc  T.ISvcShortLink2
-	log  T.ILog
-	cfg  T.ICfg
-	fs   http.FileSystem
+	hsrv   *http.Server
+	svc    T.ISvcShortLink2
+	auth   T.ISvcAuth
+	bulk   T.ISvcBulk
+	backup T.IDBBackup // nil if the db has no snapshots
+	log    T.ILog
+	cfg    T.ICfg
+	fs     http.FileSystem
+	oapi   []byte
+	reg    *M.Registry
+	mtr    *httpMetrics
+	cfgErr error       // config is checked once, it is not reloaded without restart
+	drain  atomic.Bool // readiness fails while the server drains before shutdown
 }
 
-func NewHTTPServerNet(svc T.ISvcShortLink2, log T.ILog, cfg T.ICfg) *HTTPServerNet {
+func NewHTTPServerNet(svc T.ISvcShortLink2, auth T.ISvcAuth, bulk T.ISvcBulk, backup T.IDBBackup, log T.ILog, cfg T.ICfg, reg *M.Registry) *HTTPServerNet {
 	subFS, err := fs.Sub(W.StaticFS, "data")
 	if err != nil {
 		log.LogError(fmt.Errorf("%s: %w", "staticFS: embedFS error", err))
 	}
 	return &HTTPServerNet{
-		hsrv: nil,
-		svc:  svc,
-		log:  log,
-		cfg:  cfg,
-		fs:   http.FS(subFS),
+		hsrv:   nil,
+		svc:    svc,
+		auth:   auth,
+		bulk:   bulk,
+		backup: backup,
+		log:    log,
+		cfg:    cfg,
+		fs:     http.FS(subFS),
+		oapi:   nil,
+		reg:    reg,
+		mtr:    newHTTPMetrics(reg),
+		cfgErr: cfg.Validate(),
 	}
 }
 
@@ -46,89 +65,340 @@ func NewHTTPServerNet(svc T.ISvcShortLink2, log T.ILog, cfg T.ICfg) *HTTPServerN
 */
 
 func (hns *HTTPServerNet) getRedirect(w http.ResponseWriter, r *http.Request) {
-	hash, _ := strings.CutPrefix(r.URL.Path, "/")
-	link := hns.svc.GetLinkPair(hash)
-	if len(link) == 0 {
-		http.Error(w, "", http.StatusNotFound)
+	hash := R.Param(r, "hash")
+	logHash(r, hash)
+	link, err := hns.svc.GetLinkPair(r.Context(), hash)
+	if err != nil {
+		hns.messError(w, T.HTTPMess{Hash: hash}, err)
 		return
 	}
+	if r.Method != http.MethodHead { // link checkers and previews are not clicks
+		hns.svc.Click(hash, r.Referer(), r.UserAgent())
+	}
 	http.Redirect(w, r, link, http.StatusFound)
+	hns.mtr.redirects.Inc()
+}
+
+// errStatus maps sentinel errors of service and db layers to http status codes
+func errStatus(err error) int {
+	switch {
+	case errors.Is(err, T.ErrExpired):
+		return http.StatusGone
+	case errors.Is(err, T.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, T.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, T.ErrInvalidLink), errors.Is(err, T.ErrAliasInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, T.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, T.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, T.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, T.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeJSON is the only way handlers write bodies, so links and messages are always escaped
+func (hns *HTTPServerNet) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		hns.log.LogError(fmt.Errorf("%s: %w", "writeJSON(): unable to encode response", err))
+	}
+}
+
+// messOK answers legacy endpoints with the status code in M field
+func (hns *HTTPServerNet) messOK(w http.ResponseWriter, hash, link string) {
+	hns.writeJSON(w, http.StatusOK, T.HTTPMess{Method: strconv.Itoa(http.StatusOK), Hash: hash, Link: link})
+}
+
+// messError keeps details of server side errors out of the response, they are already logged
+func (hns *HTTPServerNet) messError(w http.ResponseWriter, mess T.HTTPMess, err error) {
+	status := errStatus(err)
+	text := err.Error()
+	if status >= http.StatusInternalServerError {
+		text = http.StatusText(status)
+	}
+	hns.messStatus(w, mess, status, text)
+}
+
+// messFail is messError for middlewares, they know nothing about the request body
+func (hns *HTTPServerNet) messFail(w http.ResponseWriter, err error) {
+	hns.messError(w, T.HTTPMess{}, err)
+}
+
+func (hns *HTTPServerNet) messBadRequest(w http.ResponseWriter, mess T.HTTPMess, err error) {
+	hns.messStatus(w, mess, http.StatusBadRequest, err.Error())
+}
+
+func (hns *HTTPServerNet) messStatus(w http.ResponseWriter, mess T.HTTPMess, status int, text string) {
+	hns.writeJSON(w, status, T.HTTPMess{Method: strconv.Itoa(status), Hash: mess.Hash, Link: mess.Link, Error: text})
+}
+
+// parseExpire takes either TTL duration or absolute RFC3339 expire time, zero time means no expiration
+func parseExpire(ttlStr, expireStr string) (time.Time, error) {
+	now := time.Now()
+	switch {
+	case (len(ttlStr) != 0) && (len(expireStr) != 0):
+		return time.Time{}, fmt.Errorf("%s", "only one of TTL or expire time is allowed")
+	case len(ttlStr) != 0:
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if ttl <= 0 {
+			return time.Time{}, fmt.Errorf("%s: %s", "TTL must be positive", ttlStr)
+		}
+		return now.Add(ttl), nil
+	case len(expireStr) != 0:
+		expire, err := time.Parse(time.RFC3339, expireStr)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !expire.After(now) {
+			return time.Time{}, fmt.Errorf("%s: %s", "expire time is in the past", expireStr)
+		}
+		return expire, nil
+	}
+	return time.Time{}, nil
 }
 
 func (hns *HTTPServerNet) postLoad(w http.ResponseWriter, r *http.Request) {
 	mess := T.HTTPMess{}
 	if err := json.NewDecoder(r.Body).Decode(&mess); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		hns.messBadRequest(w, mess, err)
 		return
 	}
-	link := hns.svc.GetLinkPair(mess.Hash)
-	if len(link) == 0 {
-		http.Error(w, "", http.StatusNotFound)
+	logHash(r, mess.Hash)
+	link, err := hns.svc.GetLinkPair(r.Context(), mess.Hash)
+	if err != nil {
+		hns.messError(w, mess, err)
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"M":"200","H":"%s","L":"%s"}`+"\n", mess.Hash, link)
+	hns.messOK(w, mess.Hash, link)
 }
 
 func (hns *HTTPServerNet) postSave(w http.ResponseWriter, r *http.Request) {
 	mess := T.HTTPMess{}
 	if err := json.NewDecoder(r.Body).Decode(&mess); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		hns.messBadRequest(w, mess, err)
+		return
+	}
+	expire, err := parseExpire(mess.TTL, mess.Expire)
+	if err != nil {
+		hns.messBadRequest(w, mess, err)
+		return
+	}
+	if len(mess.Alias) != 0 {
+		hns.postSaveAlias(w, r, mess, expire)
 		return
 	}
-	hash := hns.svc.SetLinkPair(mess.Link)
-	if len(hash) == 0 {
-		http.Error(w, "not saved", http.StatusInternalServerError)
+	hash, err := hns.svc.SetLinkPair(r.Context(), mess.Link, expire)
+	if err != nil {
+		hns.messError(w, mess, err)
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"M":"200","H":"%s","L":"%s"}`+"\n", hash, mess.Link)
+	hns.messSaved(w, r, mess, hash)
+}
+
+func (hns *HTTPServerNet) postSaveAlias(w http.ResponseWriter, r *http.Request, mess T.HTTPMess, expire time.Time) {
+	hash, err := hns.svc.SetLinkAlias(r.Context(), mess.Alias, mess.Link, expire)
+	if err != nil {
+		hns.messError(w, mess, err)
+		return
+	}
+	hns.messSaved(w, r, mess, hash)
+}
+
+// messSaved answers with the canonical link as it was stored
+func (hns *HTTPServerNet) messSaved(w http.ResponseWriter, r *http.Request, mess T.HTTPMess, hash string) {
+	logHash(r, hash)
+	hns.mtr.saves.Inc()
+	link, err := hns.svc.GetLinkPair(r.Context(), hash)
+	if err != nil {
+		hns.messError(w, mess, err)
+		return
+	}
+	hns.messOK(w, hash, link)
 }
 
 func (hns *HTTPServerNet) postDelete(w http.ResponseWriter, r *http.Request) {
 	mess := T.HTTPMess{}
 	if err := json.NewDecoder(r.Body).Decode(&mess); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		hns.messBadRequest(w, mess, err)
 		return
 	}
-	link := hns.svc.GetLinkPair(mess.Hash)
-	if len(link) == 0 {
-		http.Error(w, "", http.StatusNotFound)
+	logHash(r, mess.Hash)
+	link, err := hns.svc.GetLinkPair(r.Context(), mess.Hash)
+	if (err != nil) && !errors.Is(err, T.ErrExpired) && !errors.Is(err, T.ErrForbidden) { // expired and blocked links can be deleted too
+		hns.messError(w, mess, err)
 		return
 	}
-	if !hns.svc.DelLinkPair(mess.Hash) {
-		http.Error(w, "not deleted", http.StatusInternalServerError)
+	if err := hns.svc.DelLinkPair(r.Context(), mess.Hash); err != nil {
+		hns.messError(w, mess, err)
 		return
-	} else {
-		w.Header().Add("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"M":"200","H":"%s","L":"%s"}`+"\n", mess.Hash, link)
 	}
+	hns.mtr.deletes.Inc()
+	hns.messOK(w, mess.Hash, link)
+}
+
+func (hns *HTTPServerNet) postStats(w http.ResponseWriter, r *http.Request) {
+	mess := T.HTTPMess{}
+	if err := json.NewDecoder(r.Body).Decode(&mess); err != nil {
+		hns.messBadRequest(w, mess, err)
+		return
+	}
+	logHash(r, mess.Hash)
+	stats, err := hns.svc.GetLinkStats(r.Context(), mess.Hash)
+	if err != nil {
+		hns.messError(w, mess, err)
+		return
+	}
+	hns.writeJSON(w, http.StatusOK, stats)
 }
 
 func (hns *HTTPServerNet) handlers() *R.RouteHandler {
-	middlewares := &[]*R.Middleware{
-		// R.NewMiddleware(midf1),
-		// R.NewMiddleware(midf2),
+	hash := "{hash:" + hns.svc.HashPattern() + "}"
+	limits := newRateLimits(hns.cfg, hns.log)
+	middlewares := []R.Middleware{
+		hns.accessLog(),
+		hns.countRequests(),
+		gzipResponse,
 	}
+	messKey := func(level authLevel) R.Middleware { return hns.requireKey(level, hns.messFail) }
+	apiKey := func(level authLevel) R.Middleware { return hns.requireKey(level, hns.apiError) }
+	errMess := T.HTTPMess{}
+	apiErr := T.APIError{}
 	routes := []*R.Route{
-		R.NewRoute("GET", "/[a-z0-9]{6}", hns.getRedirect),
-		R.NewRoute("POST", "/load", hns.postLoad),
-		R.NewRoute("POST", "/save", hns.postSave),
-		R.NewRoute("POST", "/delete", hns.postDelete),
+		R.NewRoute("GET", "/"+hash, hns.getRedirect).With(hns.rateLimit(limits, limits.redirect, hns.messFail)).WithDoc(R.RouteDoc{
+			Summary: "redirect to the long link",
+			Resp:    map[int]any{302: nil, 403: errMess, 404: errMess, 410: errMess, 429: errMess},
+			Headers: map[int]string{302: "Location"},
+		}),
+		R.NewRoute("POST", "/load", hns.postLoad).WithDoc(R.RouteDoc{
+			Summary: "load the long link by hash (H)", Req: T.HTTPMess{},
+			Resp: map[int]any{200: T.HTTPMess{}, 400: errMess, 403: errMess, 404: errMess, 410: errMess},
+		}),
+		R.NewRoute("POST", "/save", hns.postSave).With(messKey(authSave), hns.rateLimit(limits, limits.save, hns.messFail)).WithDoc(R.RouteDoc{
+			Summary: "save the long link (L) with optional alias (A), TTL (T) or expire time (X)", Req: T.HTTPMess{}, Auth: true,
+			Resp: map[int]any{200: T.HTTPMess{}, 400: errMess, 401: errMess, 403: errMess, 409: errMess, 429: errMess},
+		}),
+		R.NewRoute("POST", "/delete", hns.postDelete).With(messKey(authKey)).WithDoc(R.RouteDoc{
+			Summary: "delete the link by hash (H), owner or admin only", Req: T.HTTPMess{}, Auth: true,
+			Resp: map[int]any{200: T.HTTPMess{}, 400: errMess, 401: errMess, 403: errMess, 404: errMess},
+		}),
+		R.NewRoute("POST", "/stats", hns.postStats).With(messKey(authAdmin)).WithDoc(R.RouteDoc{
+			Summary: "click stats of the link by hash (H), admin only", Req: T.HTTPMess{}, Auth: true,
+			Resp: map[int]any{200: T.LinkStats{}, 400: errMess, 401: errMess, 403: errMess, 404: errMess},
+		}),
+		R.NewRoute("POST", apiLinks, hns.apiCreateLink).With(apiKey(authSave), hns.rateLimit(limits, limits.save, hns.apiError)).WithDoc(R.RouteDoc{
+			Summary: "create a short link", Req: T.APILinkReq{}, Auth: true,
+			Resp:    map[int]any{201: T.APILink{}, 400: apiErr, 401: apiErr, 403: apiErr, 409: apiErr, 429: apiErr},
+			Headers: map[int]string{201: "Location"},
+		}),
+		R.NewRoute("GET", apiLinks, hns.apiListLinks).With(apiKey(authKey)).WithDoc(R.RouteDoc{
+			Summary: "list links of the key tenant page by page, keys without tenant get their own links, admins may pass any tenant", Auth: true,
+			Query: map[string]string{"tenant": "tenant name, admin only", "cursor": "hash to list after, next of the previous page", "limit": "page size 1..1000, 100 by default"},
+			Resp:  map[int]any{200: T.APILinkList{}, 400: apiErr, 401: apiErr, 403: apiErr},
+		}),
+		R.NewRoute("GET", apiLinks+"/"+hash, hns.apiGetLink).WithDoc(R.RouteDoc{
+			Summary: "get the link",
+			Resp:    map[int]any{200: T.APILink{}, 404: apiErr, 410: apiErr},
+		}),
+		R.NewRoute("PATCH", apiLinks+"/"+hash, hns.apiUpdateLink).With(apiKey(authKey)).WithDoc(R.RouteDoc{
+			Summary: "change the link target, ttl or expire time, the alias can not be changed, owner or admin only", Req: T.APILinkReq{}, Auth: true,
+			Resp: map[int]any{200: T.APILink{}, 400: apiErr, 401: apiErr, 403: apiErr, 404: apiErr, 410: apiErr},
+		}),
+		R.NewRoute("DELETE", apiLinks+"/"+hash, hns.apiDeleteLink).With(apiKey(authKey)).WithDoc(R.RouteDoc{
+			Summary: "delete the link, owner or admin only", Auth: true,
+			Resp: map[int]any{204: nil, 401: apiErr, 403: apiErr, 404: apiErr},
+		}),
+		R.NewRoute("GET", apiLinks+"/"+hash+"/stats", hns.apiGetStats).With(apiKey(authAdmin)).WithDoc(R.RouteDoc{
+			Summary: "click stats of the link, admin only", Auth: true,
+			Resp: map[int]any{200: T.LinkStats{}, 401: apiErr, 403: apiErr, 404: apiErr},
+		}),
+		R.NewRoute("GET", apiExport, hns.apiExportLinks).With(apiKey(authAdmin)).WithDoc(R.RouteDoc{
+			Summary: "stream links of all tenants as a link file, admin only", Auth: true,
+			Query: map[string]string{"format": "json, jsonl or csv, jsonl by default"},
+			Resp:  map[int]any{200: nil, 400: apiErr, 401: apiErr, 403: apiErr},
+		}),
+		R.NewRoute("POST", apiImport, hns.apiImportLinks).With(apiKey(authAdmin)).WithDoc(R.RouteDoc{
+			Summary: "save links of the link file in the body, admin only", Auth: true,
+			Query: map[string]string{"format": "json, jsonl or csv, jsonl by default", "conflict": "skip, overwrite or fail for hashes already in the db, skip by default; overwrite resets clicks, fail saves nothing if any hash is taken"},
+			Resp:  map[int]any{200: T.BulkReport{}, 400: T.BulkReport{}, 401: apiErr, 403: apiErr, 409: T.BulkReport{}},
+		}),
+		R.NewRoute("GET", oapiSpecPath, hns.getOpenAPI).WithDoc(R.RouteDoc{
+			Summary: "this OpenAPI spec, the viewer is at /oapi/",
+			Resp:    map[int]any{200: nil},
+		}),
+		R.NewRoute("GET", oapiViewerPath, hns.getOpenAPIViewer).WithDoc(R.RouteDoc{
+			Summary: "redirect to the OpenAPI viewer at /oapi/",
+			Resp:    map[int]any{301: nil},
+			Headers: map[int]string{301: "Location"},
+		}),
+		R.NewRoute("GET", "/healthz", hns.getHealthz).WithDoc(R.RouteDoc{
+			Summary: "liveness probe, the process is up",
+			Resp:    map[int]any{200: T.Health{}},
+		}),
+		R.NewRoute("GET", "/readyz", hns.getReadyz).WithDoc(R.RouteDoc{
+			Summary: "readiness probe with status of db, config and shutdown",
+			Resp:    map[int]any{200: T.Health{}, 503: T.Health{}},
+		}),
+	}
+	switch hns.cfg.GetVal(T.SL_METRICS) {
+	case "off":
+	case "public":
+		routes = append(routes, R.NewRoute("GET", "/metrics", hns.getMetrics).WithDoc(R.RouteDoc{
+			Summary: "Prometheus metrics in text exposition format",
+			Resp:    map[int]any{200: nil},
+		}))
+	default: // admin, also for bad values as the metrics tell about the load and the db
+		routes = append(routes, R.NewRoute("GET", "/metrics", hns.getMetrics).With(apiKey(authAdmin)).WithDoc(R.RouteDoc{
+			Summary: "Prometheus metrics in text exposition format, admin only", Auth: true,
+			Resp: map[int]any{200: nil, 401: apiErr, 403: apiErr},
+		}))
 	}
+	if hns.backup != nil {
+		routes = append(routes,
+			R.NewRoute("POST", apiBackups, hns.apiCreateBackup).With(apiKey(authAdmin)).WithDoc(R.RouteDoc{
+				Summary: "make a snapshot of the db while serving, admin only", Auth: true,
+				Resp: map[int]any{201: T.Snapshot{}, 401: apiErr, 403: apiErr, 503: apiErr},
+			}),
+			R.NewRoute("GET", apiBackups, hns.apiListBackups).With(apiKey(authAdmin)).WithDoc(R.RouteDoc{
+				Summary: "list db snapshots, newest first, admin only", Auth: true,
+				Resp: map[int]any{200: []T.Snapshot{}, 401: apiErr, 403: apiErr},
+			}),
+		)
+	}
+	hns.buildOpenAPI(routes)
 	staticfs := http.StripPrefix("/", http.FileServer(hns.fs))
 	return R.NewRouteHandler(middlewares, routes, staticfs, hns.log)
 }
 
-func (hns *HTTPServerNet) Run() func(e error) {
-	hns.hsrv = &http.Server{
+// server gives the net/http server of the handler, Shutdown cancels contexts of in-flight requests,
+// so their pending db calls stop at once instead of holding Shutdown until its timeout
+func (hns *HTTPServerNet) server(handler http.Handler) *http.Server {
+	baseCtx, baseCancel := context.WithCancel(context.Background())
+	hsrv := &http.Server{
 		Addr:           hns.cfg.GetVal(T.SL_HTTP_PORT),
-		Handler:        hns.handlers(),
+		Handler:        handler,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		IdleTimeout:    10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
+		BaseContext:    func(net.Listener) context.Context { return baseCtx },
 	}
+	hsrv.RegisterOnShutdown(baseCancel)
+	return hsrv
+}
+
+func (hns *HTTPServerNet) Run() func(e error) {
+	hns.hsrv = hns.server(hns.handlers())
 	go func() {
 		defer func() {
 			if err := recover(); err != nil {
@@ -146,10 +416,13 @@ func (hns *HTTPServerNet) Run() func(e error) {
 	}()
 	hns.log.LogInfo("net/http server opened")
 	return func(e error) {
+		hns.drainTraffic()
 		ctxSHD, cancelSHD := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancelSHD()
-		if err := hns.hsrv.Shutdown(ctxSHD); err != nil {
+		err := hns.hsrv.Shutdown(ctxSHD)
+		if err != nil {
 			hns.log.LogError(fmt.Errorf("%s: %w", "Run(): net/http server graceful_shutdown error", err))
+			hns.hsrv.Close()
 		}
 		if e != nil {
 			hns.log.LogError(fmt.Errorf("%s: %w", "Run(): net/http server shutdown with error", e))
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	L "shortlink2/internal/log"
+	T "shortlink2/internal/types"
+	"testing"
+	"time"
+)
+
+type cfgMap map[string]string
+
+func (c cfgMap) GetVal(key string) string { return c[key] }
+func (c cfgMap) Parse() T.ICfg            { return c }
+func (c cfgMap) Validate() error          { return nil }
+
+// pendingDB blocks like a db call which waits for a lock, until ctx is done
+func pendingDB(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+}
+
+func TestShutdownCancelsRequests(t *testing.T) {
+	cfg := cfgMap{T.SL_LOG_LEVEL: "NOLOG"}
+	hns := &HTTPServerNet{cfg: cfg, log: L.NewLogFprintf(cfg, 0)}
+	started, done := make(chan struct{}), make(chan error, 1)
+	hsrv := hns.server(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		done <- pendingDB(r.Context())
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go hsrv.Serve(ln)
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := hsrv.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("pending db call ended with %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("shutdown waited %s for the pending db call", elapsed)
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"net/http"
+	R "shortlink2/internal/http/route"
+	M "shortlink2/internal/metrics"
+	"strconv"
+	"time"
+)
+
+/*
+	GET /metrics needs an admin API key unless SL_METRICS=public, SL_METRICS=off removes the route.
+	Prometheus scrape config:
+
+	- job_name: shortlink2
+	  authorization:
+	    credentials_file: /etc/prometheus/shortlink2.token
+	  static_configs:
+	    - targets: ["localhost:8080"]
+
+	curl localhost:8080/metrics -H "Authorization: Bearer $TOKEN"
+*/
+
+type httpMetrics struct {
+	requests  *M.Counter
+	inflight  *M.Gauge
+	latency   *M.Histogram
+	redirects *M.Counter
+	notFound  *M.Counter
+	saves     *M.Counter
+	deletes   *M.Counter
+}
+
+func newHTTPMetrics(reg *M.Registry) *httpMetrics {
+	return &httpMetrics{
+		requests:  reg.Counter("shortlink2_http_requests_total", "HTTP requests by method and status code.", "method", "code"),
+		inflight:  reg.Gauge("shortlink2_http_requests_in_flight", "HTTP requests being served."),
+		latency:   reg.Histogram("shortlink2_http_request_duration_seconds", "Latency of HTTP requests.", M.DefBuckets, "method"),
+		redirects: reg.Counter("shortlink2_redirects_total", "Redirects to long links served."),
+		notFound:  reg.Counter("shortlink2_not_found_total", "Requests answered with 404."),
+		saves:     reg.Counter("shortlink2_saves_total", "Links saved."),
+		deletes:   reg.Counter("shortlink2_deletes_total", "Links deleted."),
+	}
+}
+
+// countRequests goes right after the access log, methods out of the standard set are counted as OTHER
+func (hns *HTTPServerNet) countRequests() R.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			hns.mtr.inflight.Inc()
+			defer hns.mtr.inflight.Dec()
+			aw := &accessWriter{ResponseWriter: w}
+			next.ServeHTTP(aw, r)
+			if aw.status == 0 {
+				aw.status = http.StatusOK
+			}
+			method := r.Method
+			switch method {
+			case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+			default:
+				method = "OTHER"
+			}
+			hns.mtr.requests.Inc(method, strconv.Itoa(aw.status))
+			hns.mtr.latency.Observe(time.Since(start).Seconds(), method)
+			if aw.status == http.StatusNotFound {
+				hns.mtr.notFound.Inc()
+			}
+		})
+	}
+}
+
+func (hns *HTTPServerNet) getMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", M.ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := hns.reg.Write(w); err != nil {
+		hns.log.Ctx(r.Context()).LogDebug("%s: %s", "HTTPServerNet.getMetrics(): scrape is broken", err.Error())
+	}
+}
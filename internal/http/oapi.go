@@ -0,0 +1,199 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	R "shortlink2/internal/http/route"
+	T "shortlink2/internal/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	OpenAPI v3 spec is generated from the route table, so every route registered in handlers()
+	is documented by its R.RouteDoc. The viewer is web/data/oapi/index.html served at /oapi/
+*/
+
+const (
+	oapiSpecPath   = "/oapi/openapi.json"
+	oapiViewerPath = "/oapi" // without the route GET /oapi is taken by /{hash} and answers 404
+)
+
+// getOpenAPIViewer redirects to the viewer directory, static files are only served for misses of the route table
+func (hns *HTTPServerNet) getOpenAPIViewer(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, oapiViewerPath+"/", http.StatusMovedPermanently)
+}
+
+func (hns *HTTPServerNet) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(hns.oapi)
+}
+
+func openAPISpec(title string, routes []*R.Route) ([]byte, error) {
+	schemas := oapiSchemas{}
+	paths := map[string]map[string]any{}
+	for _, route := range routes {
+		doc := route.Doc()
+		path := route.Path()
+		pathParams := route.Params()
+		op := map[string]any{
+			"summary":   doc.Summary,
+			"responses": schemas.responses(doc),
+		}
+		params := []any{}
+		for _, name := range sortedKeys(pathParams) {
+			schema := map[string]any{"type": "string"}
+			if len(pathParams[name]) != 0 {
+				schema["pattern"] = "^" + pathParams[name] + "$"
+			}
+			params = append(params, map[string]any{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   schema,
+			})
+		}
+		for _, name := range sortedKeys(doc.Query) {
+			params = append(params, map[string]any{
+				"name":        name,
+				"in":          "query",
+				"description": doc.Query[name],
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		if len(params) != 0 {
+			op["parameters"] = params
+		}
+		if doc.Req != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemas.schemaOf(reflect.TypeOf(doc.Req))}},
+			}
+		}
+		if doc.Auth {
+			op["security"] = []any{map[string]any{"bearer": []string{}}}
+		}
+		if _, ok := paths[path]; !ok {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(route.Method())] = op
+	}
+	spec := map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]any{"title": title, "version": "1"},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas":         schemas,
+			"securitySchemes": map[string]any{"bearer": map[string]any{"type": "http", "scheme": "bearer"}},
+		},
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// oapiSchemas collects named struct schemas for components/schemas
+type oapiSchemas map[string]any
+
+func (sc oapiSchemas) responses(doc R.RouteDoc) map[string]any {
+	resps := map[string]any{}
+	for status, body := range doc.Resp {
+		resp := map[string]any{"description": http.StatusText(status)}
+		switch b := body.(type) {
+		case nil:
+		case string:
+			resp["description"] = b
+			resp["content"] = map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}}
+		default:
+			resp["content"] = map[string]any{"application/json": map[string]any{"schema": sc.schemaOf(reflect.TypeOf(b))}}
+		}
+		if header, ok := doc.Headers[status]; ok {
+			resp["headers"] = map[string]any{header: map[string]any{"schema": map[string]any{"type": "string"}}}
+		}
+		resps[strconv.Itoa(status)] = resp
+	}
+	if len(resps) == 0 {
+		resps["default"] = map[string]any{"description": "any response"}
+	}
+	return resps
+}
+
+func (sc oapiSchemas) schemaOf(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": sc.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": sc.schemaOf(t.Elem())}
+	case reflect.Struct:
+		return sc.structRef(t)
+	default:
+		return map[string]any{}
+	}
+}
+
+// structRef puts struct schema to components once and refers to it by type name
+func (sc oapiSchemas) structRef(t reflect.Type) map[string]any {
+	ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	if _, ok := sc[t.Name()]; ok {
+		return ref
+	}
+	sc[t.Name()] = nil // recursive types stop here
+	props := map[string]any{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || (tag == "-") {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if len(name) == 0 {
+			name = field.Name
+		}
+		props[name] = sc.schemaOf(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": props}
+	if len(required) != 0 {
+		schema["required"] = required
+	}
+	sc[t.Name()] = schema
+	return ref
+}
+
+func (hns *HTTPServerNet) buildOpenAPI(routes []*R.Route) {
+	spec, err := openAPISpec(hns.cfg.GetVal(T.SL_APP_NAME), routes)
+	if err != nil {
+		hns.log.LogError(fmt.Errorf("%s: %w", "buildOpenAPI(): unable to build OpenAPI spec", err))
+		return
+	}
+	hns.oapi = spec
+}
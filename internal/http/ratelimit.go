@@ -0,0 +1,190 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	R "shortlink2/internal/http/route"
+	T "shortlink2/internal/types"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Token bucket per client and class of routes: redirects and saves have their own limiters.
+	The limiter goes after auth in the route chain, so authenticated clients are counted by
+	API key and anonymous ones by IP, IPv6 clients by /64 as one host usually has the whole
+	network. Client IP is the first untrusted address of X-Forwarded-For if the peer is a
+	trusted proxy. Unknown tokens are answered with 401 before the limiter, so they can not
+	make buckets.
+*/
+
+const (
+	sweepTime  = time.Minute // how often idle full buckets are dropped
+	maxBuckets = 100000      // beyond it random buckets are dropped, a flood of clients can not grow the map
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type limiter struct {
+	rate    float64 // tokens per second, 0 disables the limiter
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newLimiter(cfg T.ICfg, log T.ILog, rateKey, burstKey string) *limiter {
+	l := &limiter{buckets: map[string]*bucket{}, swept: time.Now()}
+	rate, err1 := strconv.ParseFloat(cfg.GetVal(rateKey), 64)
+	burst, err2 := strconv.Atoi(cfg.GetVal(burstKey))
+	if (err1 != nil) || (err2 != nil) || (rate < 0) || (burst < 1) {
+		log.LogError(fmt.Errorf("%s: %s=%s %s=%s", "newLimiter(): bad rate limit, limiter is disabled",
+			rateKey, cfg.GetVal(rateKey), burstKey, cfg.GetVal(burstKey)))
+		return l
+	}
+	l.rate, l.burst = rate, float64(burst)
+	return l
+}
+
+// allow takes a token from the client bucket, or returns how long to wait for the next one
+func (l *limiter) allow(client string, now time.Time) (bool, time.Duration) {
+	if l.rate == 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > sweepTime {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.sweep(now)
+		}
+		for victim := range l.buckets { // map order is random
+			if len(l.buckets) < maxBuckets {
+				break
+			}
+			delete(l.buckets, victim)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep drops buckets which are full again, they are the same as new ones
+func (l *limiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.swept = now
+}
+
+type rateLimits struct {
+	redirect *limiter
+	save     *limiter
+	trusted  []*net.IPNet
+}
+
+func newRateLimits(cfg T.ICfg, log T.ILog) *rateLimits {
+	rl := &rateLimits{
+		redirect: newLimiter(cfg, log, T.SL_RATE_REDIRECT, T.SL_RATE_REDIRECT_BURST),
+		save:     newLimiter(cfg, log, T.SL_RATE_SAVE, T.SL_RATE_SAVE_BURST),
+		trusted:  []*net.IPNet{},
+	}
+	for _, cidr := range strings.Split(cfg.GetVal(T.SL_RATE_TRUSTED), ",") {
+		if cidr = strings.TrimSpace(cidr); len(cidr) == 0 {
+			continue
+		}
+		if !strings.Contains(cidr, "/") { // single address
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.LogError(fmt.Errorf("%s: %w", "newRateLimits(): bad trusted proxy, skipped", err))
+			continue
+		}
+		rl.trusted = append(rl.trusted, ipnet)
+	}
+	return rl
+}
+
+func (rl *rateLimits) isTrusted(ip net.IP) bool {
+	for _, ipnet := range rl.trusted {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP walks X-Forwarded-For from the right while hops are trusted proxies
+func (rl *rateLimits) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if (ip == nil) || !rl.isTrusted(ip) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		host = hop.String()
+		if !rl.isTrusted(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// client is the API key of authenticated requests and the client IP of anonymous ones
+func (rl *rateLimits) client(r *http.Request) string {
+	if key, ok := T.APIKeyFrom(r.Context()); ok {
+		return "key:" + key.ID
+	}
+	host := rl.clientIP(r)
+	if ip := net.ParseIP(host); (ip != nil) && (ip.To4() == nil) {
+		return "ip:" + ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return "ip:" + host
+}
+
+// rateLimit is the middleware, it answers 429 with Retry-After and stops the request
+func (hns *HTTPServerNet) rateLimit(rl *rateLimits, lim *limiter, fail func(w http.ResponseWriter, err error)) R.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, retry := lim.allow(rl.client(r), time.Now())
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+				fail(w, fmt.Errorf("%w: retry in %s", T.ErrRateLimited, retry.Round(time.Millisecond)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
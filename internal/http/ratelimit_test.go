@@ -0,0 +1,73 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	T "shortlink2/internal/types"
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Now()
+	l := &limiter{rate: 1, burst: 2, buckets: map[string]*bucket{}, swept: now}
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := l.allow("a", now); ok != want {
+			t.Fatalf("request %d: allowed %v, want %v", i, ok, want)
+		}
+	}
+	if ok, retry := l.allow("a", now); ok || (retry != time.Second) {
+		t.Fatalf("empty bucket: allowed %v, retry %s", ok, retry)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Fatal("other client shares the bucket")
+	}
+	if ok, _ := l.allow("a", now.Add(time.Second)); !ok {
+		t.Fatal("bucket is not refilled")
+	}
+}
+
+func TestLimiterBound(t *testing.T) {
+	now := time.Now()
+	l := &limiter{rate: 1, burst: 1, buckets: map[string]*bucket{}, swept: now}
+	for i := 0; i < maxBuckets+10; i++ {
+		l.allow(fmt.Sprintf("ip:%d", i), now)
+	}
+	if len(l.buckets) > maxBuckets {
+		t.Fatalf("%d buckets, want at most %d", len(l.buckets), maxBuckets)
+	}
+}
+
+func TestRateLimitsClient(t *testing.T) {
+	_, proxy, _ := net.ParseCIDR("10.0.0.0/8")
+	rl := &rateLimits{trusted: []*net.IPNet{proxy}}
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		key    *T.APIKey
+		want   string
+	}{
+		{"ipv4", "192.0.2.1:1234", "", nil, "ip:192.0.2.1"},
+		{"untrusted xff", "192.0.2.1:1234", "198.51.100.7", nil, "ip:192.0.2.1"},
+		{"trusted xff", "10.0.0.1:1234", "198.51.100.7, 10.0.0.2", nil, "ip:198.51.100.7"},
+		{"ipv6 by /64", "[2001:db8:1:2:3:4:5:6]:1234", "", nil, "ip:2001:db8:1:2::/64"},
+		{"api key", "192.0.2.1:1234", "", &T.APIKey{ID: "k1"}, "key:k1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.key != nil {
+				r = r.WithContext(T.WithAPIKey(r.Context(), *tt.key))
+			}
+			if got := rl.client(r); got != tt.want {
+				t.Fatalf("client() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,90 +1,165 @@
 package route
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"regexp"
 	T "shortlink2/internal/types"
+	"strings"
 )
 
+// Route pattern is a path template with params, e.g. /api/v1/links/{hash:[0-9a-z]{6}}/stats
 type Route struct {
 	method  string
-	regex   *regexp.Regexp
-	handler http.HandlerFunc
+	pattern string
+	handler http.Handler
+	doc     RouteDoc
 }
 
 func NewRoute(method, pattern string, handler http.HandlerFunc) *Route {
 	return &Route{
-		method,
-		regexp.MustCompile("^" + pattern + "$"),
-		handler,
+		method:  method,
+		pattern: pattern,
+		handler: handler,
 	}
 }
 
-type Middleware struct {
-	handler http.HandlerFunc
+// With wraps the route handler with its own middlewares, they run after the global ones
+func (r *Route) With(middlewares ...Middleware) *Route {
+	r.handler = Chain(r.handler, middlewares...)
+	return r
 }
 
-func NewMiddleware(handler http.HandlerFunc) *Middleware {
-	return &Middleware{
-		handler,
+// RouteDoc describes the route for OpenAPI spec
+type RouteDoc struct {
+	Query   map[string]string // optional query param name -> description
+	Summary string
+	Req     any            // request body sample, nil if there is no body
+	Resp    map[int]any    // response body samples by status, nil means no body, string means text/plain
+	Headers map[int]string // response header name by status, e.g. Location
+	Auth    bool           // bearer API key is required
+}
+
+func (r *Route) WithDoc(doc RouteDoc) *Route {
+	r.doc = doc
+	return r
+}
+
+func (r *Route) Method() string {
+	return r.method
+}
+
+func (r *Route) Pattern() string {
+	return r.pattern
+}
+
+// Path is the pattern without param regexes, e.g. /api/v1/links/{hash}/stats
+func (r *Route) Path() string {
+	segs, _ := splitPattern(r.pattern)
+	for i, seg := range segs {
+		if name, _, ok := parseParam(seg); ok {
+			segs[i] = "{" + name + "}"
+		}
+	}
+	return "/" + strings.Join(segs, "/")
+}
+
+// Params gives path param regexes by name, empty regex matches any segment
+func (r *Route) Params() map[string]string {
+	params := map[string]string{}
+	segs, _ := splitPattern(r.pattern)
+	for _, seg := range segs {
+		if name, regex, ok := parseParam(seg); ok {
+			params[name] = regex
+		}
+	}
+	return params
+}
+
+func (r *Route) Doc() RouteDoc {
+	return r.doc
+}
+
+// Middleware wraps the next handler: it may answer and not call next, wrap the ResponseWriter
+// or pass values downstream with the request context
+type Middleware func(next http.Handler) http.Handler
+
+// Chain applies middlewares so the first one is the outermost
+func Chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
 	}
+	return handler
 }
 
 type RouteHandler struct {
-	middlewares *[]*Middleware
-	routes      []*Route
-	staticfs    http.Handler
-	log         T.ILog
-}
-
-func NewRouteHandler(middlewares *[]*Middleware, routes []*Route, staticfs http.Handler, log T.ILog) *RouteHandler {
-	return &RouteHandler{
-		middlewares,
-		routes,
-		staticfs,
-		log,
+	handler  http.Handler // global middlewares around route
+	root     *node
+	staticfs http.Handler
+	log      T.ILog
+}
+
+// NewRouteHandler panics on bad or duplicated patterns, like regexp.MustCompile does
+func NewRouteHandler(middlewares []Middleware, routes []*Route, staticfs http.Handler, log T.ILog) *RouteHandler {
+	rh := &RouteHandler{
+		root:     newNode(),
+		staticfs: staticfs,
+		log:      log,
+	}
+	for _, route := range routes {
+		if err := rh.root.insert(route); err != nil {
+			panic(fmt.Errorf("%s: %w", "NewRouteHandler()", err))
+		}
 	}
+	rh.handler = Chain(http.HandlerFunc(rh.route), middlewares...)
+	return rh
 }
 
 func (rh *RouteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	defer func() {
-		if err := recover(); err != nil {
-			rh.log.LogError(fmt.Errorf("%s: %w", "500: some handler panics", err.(error)))
-			http.Error(w, "500 internal server error", http.StatusInternalServerError)
-		}
-	}()
+	defer rh.recoverPanic(w, r)
+	rh.handler.ServeHTTP(w, r)
+}
 
-	if rh.middlewares != nil {
-		for _, middlwr := range *rh.middlewares {
-			middlwr.handler(w, r)
-		}
+// recoverPanic goes in route too, so global middlewares like access log see the 500 of handlers
+func (rh *RouteHandler) recoverPanic(w http.ResponseWriter, r *http.Request) {
+	if err := recover(); err != nil {
+		rh.log.Ctx(r.Context()).LogError(fmt.Errorf("%s: %v", "500: some handler panics", err))
+		writeError(w, http.StatusInternalServerError)
 	}
+}
 
-	if len(rh.routes) == 0 {
-		rh.log.LogError(fmt.Errorf("%s: %s", "500 internal server error", "empty routes"))
-		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+// route serves HEAD by GET route and OPTIONS by Allow header unless they have own routes
+func (rh *RouteHandler) route(w http.ResponseWriter, r *http.Request) {
+	defer rh.recoverPanic(w, r)
+	vals := map[string]string{}
+	n := rh.root.lookup(strings.Split(r.URL.Path, "/")[1:], vals)
+	if n == nil {
+		if rh.staticfs != nil {
+			rh.staticfs.ServeHTTP(w, r)
+		} else {
+			writeError(w, http.StatusNotFound)
+		}
 		return
 	}
-	isWrongMethod := false
-	for _, route := range rh.routes {
-		if route.regex.MatchString(r.URL.Path) {
-			if r.Method != route.method {
-				isWrongMethod = true
-				continue
-			}
-			route.handler(w, r)
-			return
-		}
+	route, ok := n.routes[r.Method]
+	if !ok && (r.Method == http.MethodHead) {
+		route, ok = n.routes[http.MethodGet]
 	}
-	if isWrongMethod {
-		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
-		return
+	switch {
+	case ok:
+		route.handler.ServeHTTP(w, withParams(r, vals))
+	case r.Method == http.MethodOptions:
+		w.Header().Set("Allow", n.allow())
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", n.allow())
+		writeError(w, http.StatusMethodNotAllowed)
 	}
+}
 
-	if rh.staticfs != nil {
-		rh.staticfs.ServeHTTP(w, r)
-	} else {
-		http.NotFound(w, r)
-	}
+// writeError answers with the same envelope as API handlers do
+func writeError(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(T.APIError{Error: T.APIErrorBody{Status: status, Message: http.StatusText(status)}})
 }
@@ -0,0 +1,169 @@
+package route
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+	Routes are kept in a trie of path segments, so lookup depends on the path depth, not on
+	the number of routes. Pattern segments are static ("links") or params: "{hash}" matches
+	any segment, "{hash:[0-9a-z]{6}}" matches the regex. Static children win over params,
+	params are tried in the order of registration.
+*/
+
+type param struct {
+	name  string
+	regex *regexp.Regexp // nil matches any non-empty segment
+	node  *node
+}
+
+type node struct {
+	static map[string]*node
+	params []*param
+	routes map[string]*Route // by method
+}
+
+func newNode() *node {
+	return &node{static: map[string]*node{}, routes: map[string]*Route{}}
+}
+
+// splitPattern splits by '/' outside of braces, param regex may have its own {n,m}
+func splitPattern(pattern string) ([]string, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("%s: %s", "pattern must start with /", pattern)
+	}
+	segs := []string{}
+	depth, start := 0, 1
+	for i := 1; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("%s: %s", "unbalanced braces", pattern)
+			}
+		case '/':
+			if depth == 0 {
+				segs = append(segs, pattern[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("%s: %s", "unbalanced braces", pattern)
+	}
+	return append(segs, pattern[start:]), nil
+}
+
+// parseParam gives name and regex of "{name}" or "{name:regex}", ok is false for static segments
+func parseParam(seg string) (name, regex string, ok bool) {
+	if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") {
+		return "", "", false
+	}
+	name, regex, _ = strings.Cut(seg[1:len(seg)-1], ":")
+	return name, regex, true
+}
+
+func (n *node) insert(route *Route) error {
+	segs, err := splitPattern(route.pattern)
+	if err != nil {
+		return err
+	}
+	cur := n
+	for _, seg := range segs {
+		name, regex, ok := parseParam(seg)
+		if !ok {
+			next, found := cur.static[seg]
+			if !found {
+				next = newNode()
+				cur.static[seg] = next
+			}
+			cur = next
+			continue
+		}
+		var next *node
+		for _, p := range cur.params {
+			if (p.name == name) && (((p.regex == nil) && (len(regex) == 0)) || ((p.regex != nil) && (p.regex.String() == "^(?:"+regex+")$"))) {
+				next = p.node
+			}
+		}
+		if next == nil {
+			p := &param{name: name, node: newNode()}
+			if len(regex) != 0 {
+				if p.regex, err = regexp.Compile("^(?:" + regex + ")$"); err != nil {
+					return fmt.Errorf("%s %s: %w", "bad param regex in", route.pattern, err)
+				}
+			}
+			cur.params = append(cur.params, p)
+			next = p.node
+		}
+		cur = next
+	}
+	if _, dup := cur.routes[route.method]; dup {
+		return fmt.Errorf("%s: %s %s", "duplicated route", route.method, route.pattern)
+	}
+	cur.routes[route.method] = route
+	return nil
+}
+
+// lookup finds the node of the path and collects param values, nil if no route has such path
+func (n *node) lookup(segs []string, vals map[string]string) *node {
+	if len(segs) == 0 {
+		if len(n.routes) == 0 {
+			return nil
+		}
+		return n
+	}
+	seg, rest := segs[0], segs[1:]
+	if next, ok := n.static[seg]; ok {
+		if res := next.lookup(rest, vals); res != nil {
+			return res
+		}
+	}
+	for _, p := range n.params {
+		if (len(seg) == 0) || ((p.regex != nil) && !p.regex.MatchString(seg)) {
+			continue
+		}
+		if res := p.node.lookup(rest, vals); res != nil {
+			vals[p.name] = seg
+			return res
+		}
+	}
+	return nil
+}
+
+// allow lists methods of the node for Allow header, HEAD and OPTIONS are implicit
+func (n *node) allow() string {
+	methods := []string{http.MethodOptions}
+	for method := range n.routes {
+		methods = append(methods, method)
+	}
+	if _, ok := n.routes[http.MethodGet]; ok {
+		if _, ok := n.routes[http.MethodHead]; !ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+type paramsCtx struct{}
+
+// Param gives the path param value of the matched route, empty if there is no such param
+func Param(r *http.Request, name string) string {
+	vals, _ := r.Context().Value(paramsCtx{}).(map[string]string)
+	return vals[name]
+}
+
+func withParams(r *http.Request, vals map[string]string) *http.Request {
+	if len(vals) == 0 {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), paramsCtx{}, vals))
+}
@@ -0,0 +1,128 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// named answers with the route name and its params, so tests see which route was taken
+func named(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Route", name)
+		w.Header().Set("X-Hash", Param(r, "hash"))
+		w.Write([]byte(name))
+	}
+}
+
+func testHandler() *RouteHandler {
+	hash := "{hash:[0-9a-z]{4,8}}"
+	routes := []*Route{
+		NewRoute("GET", "/"+hash, named("redirect")),
+		NewRoute("POST", "/save", named("save")),
+		NewRoute("GET", "/oapi", named("viewer")),
+		NewRoute("GET", "/oapi/openapi.json", named("spec")),
+		NewRoute("GET", "/api/v1/links", named("list")),
+		NewRoute("POST", "/api/v1/links", named("create")),
+		NewRoute("GET", "/api/v1/links/"+hash, named("get")),
+		NewRoute("DELETE", "/api/v1/links/"+hash, named("delete")),
+		NewRoute("GET", "/api/v1/links/"+hash+"/stats", named("stats")),
+		NewRoute("GET", "/api/v1/links/export", named("export")),
+	}
+	staticfs := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Route", "static")
+	})
+	return NewRouteHandler(nil, routes, staticfs, nil)
+}
+
+func TestRoute(t *testing.T) {
+	rh := testHandler()
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		route  string
+		hash   string
+		allow  string
+	}{
+		{"param", "GET", "/abcd12", 200, "redirect", "abcd12", ""},
+		{"param regex miss goes static", "GET", "/ab", 200, "static", "", ""},
+		{"static file", "GET", "/favicon.png", 200, "static", "", ""},
+		{"static wins over param", "GET", "/oapi", 200, "viewer", "", ""},
+		{"static subtree", "GET", "/oapi/openapi.json", 200, "spec", "", ""},
+		{"static miss in subtree goes static", "GET", "/oapi/index.html", 200, "static", "", ""},
+		{"nested param", "GET", "/api/v1/links/abcd12", 200, "get", "abcd12", ""},
+		{"static sibling of param", "GET", "/api/v1/links/export", 200, "export", "", ""},
+		{"backtrack into param", "GET", "/api/v1/links/export/stats", 200, "stats", "export", ""},
+		{"method of the same path", "DELETE", "/api/v1/links/abcd12", 200, "delete", "abcd12", ""},
+		{"head by get", "HEAD", "/abcd12", 200, "redirect", "abcd12", ""},
+		{"options", "OPTIONS", "/api/v1/links", 204, "", "", "GET, HEAD, OPTIONS, POST"},
+		{"options of post only", "OPTIONS", "/save", 204, "", "", "OPTIONS, POST"},
+		{"not allowed", "PUT", "/api/v1/links/abcd12", 405, "", "", "DELETE, GET, HEAD, OPTIONS"},
+		{"head of post only", "HEAD", "/save", 405, "", "", "OPTIONS, POST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rh.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("X-Route"); got != tt.route {
+				t.Fatalf("route %q, want %q", got, tt.route)
+			}
+			if got := w.Header().Get("X-Hash"); got != tt.hash {
+				t.Fatalf("hash %q, want %q", got, tt.hash)
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Fatalf("Allow %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestRouteMiddlewares(t *testing.T) {
+	order := ""
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order += name
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	routes := []*Route{NewRoute("GET", "/x", func(w http.ResponseWriter, r *http.Request) { order += "h" }).With(mark("c"), mark("d"))}
+	rh := NewRouteHandler([]Middleware{mark("a"), mark("b")}, routes, nil, nil)
+	rh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/x", nil))
+	if order != "abcdh" {
+		t.Fatalf("order %q, want %q", order, "abcdh")
+	}
+	w := httptest.NewRecorder()
+	rh.ServeHTTP(w, httptest.NewRequest("GET", "/y", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status %d without static files, want 404", w.Code)
+	}
+}
+
+func TestInsertErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []*Route
+	}{
+		{"no slash", []*Route{NewRoute("GET", "x", nil)}},
+		{"unbalanced", []*Route{NewRoute("GET", "/{hash", nil)}},
+		{"bad regex", []*Route{NewRoute("GET", "/{hash:[}", nil)}},
+		{"duplicate", []*Route{NewRoute("GET", "/{hash:[a-z]+}", nil), NewRoute("GET", "/{hash:[a-z]+}", nil)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("no panic")
+				}
+			}()
+			NewRouteHandler(nil, tt.routes, nil, nil)
+		})
+	}
+}
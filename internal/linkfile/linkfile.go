@@ -0,0 +1,249 @@
+/*
+	Link files module, fixtures and bulk data of links:
+
+- formats by file extension: .json (array), .jsonl (object per line), .csv (with header)
+- records have the shape of API links: hash, link, expire and created (RFC3339), owner, tenant
+- streaming reader and writer, big files are not loaded into memory
+- CSV columns go in any order, hash and link are required
+
+	[{"hash":"5clp60","link":"http://lib.ru"},{"hash":"x7","link":"http://go.dev","expire":"2030-01-01T00:00:00Z"}]
+
+	hash,link,expire,owner,tenant,created
+	5clp60,http://lib.ru,,,,
+*/
+package linkfile
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	T "shortlink2/internal/types"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	JSON  = "json"
+	JSONL = "jsonl"
+	CSV   = "csv"
+)
+
+// Formats are the link file formats of readers and writers
+var Formats = []string{JSON, JSONL, CSV}
+
+var csvColumns = []string{"hash", "link", "expire", "owner", "tenant", "created"}
+
+// FormatOf takes the format from the file extension
+func FormatOf(fname string) (string, error) {
+	switch format := strings.TrimPrefix(strings.ToLower(filepath.Ext(fname)), "."); format {
+	case JSON, JSONL, CSV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("%s: %s", "unknown link file format, use .json, .jsonl or .csv", fname)
+	}
+}
+
+type Reader struct {
+	format string
+	json   *json.Decoder
+	lines  *bufio.Scanner
+	csv    *csv.Reader
+	cols   map[string]int
+	n      int  // records read, for error messages
+	done   bool // json array is closed
+}
+
+func NewReader(r io.Reader, format string) (*Reader, error) {
+	lr := &Reader{format: format}
+	switch format {
+	case JSON:
+		lr.json = json.NewDecoder(r)
+		if tok, err := lr.json.Token(); (err != nil) || (tok != json.Delim('[')) {
+			return nil, fmt.Errorf("%s", "json link file must be an array of links")
+		}
+	case JSONL:
+		lr.lines = bufio.NewScanner(r)
+		lr.lines.Buffer(make([]byte, 64*1024), 1024*1024)
+	case CSV:
+		lr.csv = csv.NewReader(r)
+		lr.csv.FieldsPerRecord = -1
+		header, err := lr.csv.Read()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", "csv link file must have a header", err)
+		}
+		lr.cols = map[string]int{}
+		for i, col := range header {
+			col = strings.ToLower(strings.TrimSpace(col))
+			if !slices.Contains(csvColumns, col) {
+				return nil, fmt.Errorf("unknown csv column %q, known are %s", col, strings.Join(csvColumns, ","))
+			}
+			lr.cols[col] = i
+		}
+		if _, ok := lr.cols["hash"]; !ok {
+			return nil, fmt.Errorf("%s", "csv link file has no hash column")
+		}
+		if _, ok := lr.cols["link"]; !ok {
+			return nil, fmt.Errorf("%s", "csv link file has no link column")
+		}
+	default:
+		return nil, fmt.Errorf("unknown link file format %q", format)
+	}
+	return lr, nil
+}
+
+// closeJSON checks the array ends with ']' and nothing follows it, a cut file is an error, not io.EOF
+func (lr *Reader) closeJSON() error {
+	if tok, err := lr.json.Token(); (err != nil) || (tok != json.Delim(']')) {
+		return fmt.Errorf("link %d: %s", lr.n+1, "json link file is cut, the array has no closing ]")
+	}
+	if _, err := lr.json.Token(); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s", "json link file has data after the array")
+	}
+	lr.done = true
+	return io.EOF
+}
+
+// Next gives the next link, io.EOF after the last one
+func (lr *Reader) Next() (T.DBMess, error) {
+	var rec T.APILink
+	var err error
+	switch lr.format {
+	case JSON:
+		if lr.done {
+			return T.DBMess{}, io.EOF
+		}
+		if !lr.json.More() {
+			return T.DBMess{}, lr.closeJSON()
+		}
+		err = lr.json.Decode(&rec)
+	case JSONL:
+		for {
+			if !lr.lines.Scan() {
+				if err := lr.lines.Err(); err != nil {
+					return T.DBMess{}, err
+				}
+				return T.DBMess{}, io.EOF
+			}
+			if line := strings.TrimSpace(lr.lines.Text()); len(line) != 0 {
+				err = json.Unmarshal([]byte(line), &rec)
+				break
+			}
+		}
+	case CSV:
+		rec, err = lr.nextCSV()
+		if errors.Is(err, io.EOF) {
+			return T.DBMess{}, io.EOF
+		}
+	}
+	lr.n++
+	if err != nil {
+		return T.DBMess{}, fmt.Errorf("link %d: %w", lr.n, err)
+	}
+	if (len(rec.Hash) == 0) || (len(rec.Link) == 0) {
+		return T.DBMess{}, fmt.Errorf("link %d: %s", lr.n, "hash and link are required")
+	}
+	return rec.DBMess(), nil
+}
+
+func (lr *Reader) nextCSV() (T.APILink, error) {
+	row, err := lr.csv.Read()
+	if err != nil {
+		return T.APILink{}, err
+	}
+	col := func(name string) string {
+		if i, ok := lr.cols[name]; ok && (i < len(row)) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	rec := T.APILink{Hash: col("hash"), Link: col("link"), Owner: col("owner"), Tenant: col("tenant")}
+	for _, tcol := range []struct {
+		name string
+		dst  **time.Time
+	}{{"expire", &rec.Expire}, {"created", &rec.Created}} {
+		if str := col(tcol.name); len(str) != 0 {
+			t, err := time.Parse(time.RFC3339, str)
+			if err != nil {
+				return T.APILink{}, fmt.Errorf("%s: %w", tcol.name, err)
+			}
+			*tcol.dst = &t
+		}
+	}
+	return rec, nil
+}
+
+type Writer struct {
+	format string
+	w      *bufio.Writer
+	csv    *csv.Writer
+	n      int
+}
+
+// NewWriter starts the file, Close must be called to finish it
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	lw := &Writer{format: format, w: bufio.NewWriter(w)}
+	switch format {
+	case JSON:
+		_, err := lw.w.WriteString("[\n")
+		return lw, err
+	case JSONL:
+		return lw, nil
+	case CSV:
+		lw.csv = csv.NewWriter(lw.w)
+		return lw, lw.csv.Write(csvColumns)
+	default:
+		return nil, fmt.Errorf("unknown link file format %q", format)
+	}
+}
+
+func (lw *Writer) Write(pair T.DBMess) error {
+	rec := T.NewAPILink(pair)
+	lw.n++
+	if lw.format == CSV {
+		rfc3339 := func(t *time.Time) string {
+			if t == nil {
+				return ""
+			}
+			return t.Format(time.RFC3339)
+		}
+		return lw.csv.Write([]string{rec.Hash, rec.Link, rfc3339(rec.Expire), rec.Owner, rec.Tenant, rfc3339(rec.Created)})
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if (lw.format == JSON) && (lw.n > 1) {
+		lw.w.WriteString(",\n")
+	}
+	lw.w.Write(line)
+	if lw.format == JSONL {
+		lw.w.WriteString("\n")
+	}
+	return nil
+}
+
+// Flush sends written links to the underlying writer, for progress of long streams
+func (lw *Writer) Flush() error {
+	if lw.csv != nil {
+		lw.csv.Flush()
+		if err := lw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return lw.w.Flush()
+}
+
+func (lw *Writer) Close() error {
+	if lw.format == JSON {
+		if lw.n != 0 {
+			lw.w.WriteString("\n")
+		}
+		lw.w.WriteString("]\n")
+	}
+	return lw.Flush()
+}
@@ -0,0 +1,62 @@
+package linkfile
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// readAll gives hashes of the file and the error which stopped reading, nil for io.EOF
+func readAll(t *testing.T, text, format string) ([]string, error) {
+	lr, err := NewReader(strings.NewReader(text), format)
+	if err != nil {
+		return nil, err
+	}
+	hashes := []string{}
+	for {
+		pair, err := lr.Next()
+		if errors.Is(err, io.EOF) {
+			if _, err := lr.Next(); !errors.Is(err, io.EOF) {
+				t.Fatalf("Next() after io.EOF: %v", err)
+			}
+			return hashes, nil
+		}
+		if err != nil {
+			return hashes, err
+		}
+		hashes = append(hashes, pair.Hash)
+	}
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		text   string
+		hashes int
+		fail   bool
+	}{
+		{"json", JSON, `[{"hash":"a1","link":"http://a"},{"hash":"b2","link":"http://b"}]`, 2, false},
+		{"json empty", JSON, " [ ]\n", 0, false},
+		{"json cut", JSON, `[{"hash":"a1","link":"http://a"}`, 1, true},
+		{"json cut after comma", JSON, `[{"hash":"a1","link":"http://a"},`, 1, true},
+		{"json trailing data", JSON, `[{"hash":"a1","link":"http://a"}] {}`, 1, true},
+		{"json not array", JSON, `{"hash":"a1","link":"http://a"}`, 0, true},
+		{"jsonl", JSONL, "{\"hash\":\"a1\",\"link\":\"http://a\"}\n\n{\"hash\":\"b2\",\"link\":\"http://b\"}\n", 2, false},
+		{"jsonl no link", JSONL, `{"hash":"a1"}`, 0, true},
+		{"csv", CSV, "link,hash\nhttp://a,a1\n", 1, false},
+		{"csv unknown column", CSV, "hash,link,color\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hashes, err := readAll(t, tt.text, tt.format)
+			if (err != nil) != tt.fail {
+				t.Fatalf("error %v, want failure %v", err, tt.fail)
+			}
+			if len(hashes) != tt.hashes {
+				t.Fatalf("%d links read, want %d", len(hashes), tt.hashes)
+			}
+		})
+	}
+}
@@ -2,13 +2,13 @@
 	Fprintf log module:
 
 - universal DI interface (see types/log.go)
-- structured log to JSON
-- manual key positions into JSON object
+- structured log to JSON, messages are JSON escaped
+- request id "R" key of loggers made by Ctx(ctx)
+- manual key positions into JSON object, extra keys by LogInfoFields
 - 8 Log levels (trace, debug, info, warn, error, panic, fatal, nolog)
 - stack trace in Panic and Fatal log messages (os.Exit(1) on Fatal)
 - multi-target message sending with io.Writer interface (if empty - os.Stderr)
 - log batching with timeout (if 0 - no batching)
-- log metrics from "runtime/metrics" with timeout (if 0 - no metrics)
 - Error, Panic, Fatal has filepath and line number
 - ASSERT, TODO, UNREACHABLE dev messages are sending to os.Stdout
 */
@@ -16,12 +16,12 @@ package log
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
 	"runtime/debug"
-	"runtime/metrics"
 	T "shortlink2/internal/types"
 	"strings"
 	"sync"
@@ -55,17 +55,17 @@ const (
 )
 
 type LogFprintf struct {
-	loglvl     LogLevel
-	host       string
-	svc        string
-	targets    []io.Writer
-	batchTime  time.Duration
-	logbuf     strings.Builder
-	mu         sync.Mutex
-	metricTime time.Duration
+	loglvl    LogLevel
+	host      string
+	svc       string
+	targets   []io.Writer
+	batchTime time.Duration
+	logbuf    *strings.Builder // shared with Ctx() loggers
+	mu        *sync.Mutex
+	rid       string
 }
 
-func NewLogFprintf(cfg T.ICfg, metricTime time.Duration, batchTime time.Duration, targets ...io.Writer) *LogFprintf {
+func NewLogFprintf(cfg T.ICfg, batchTime time.Duration, targets ...io.Writer) *LogFprintf {
 	debug.SetTraceback("all")
 	if len(targets) == 0 {
 		targets = append(targets, os.Stderr)
@@ -91,15 +91,27 @@ func NewLogFprintf(cfg T.ICfg, metricTime time.Duration, batchTime time.Duration
 		lvl = NoLog
 	}
 	return &LogFprintf{
-		loglvl:     lvl,
-		host:       cfg.GetVal(T.SL_HTTP_IP) + cfg.GetVal(T.SL_HTTP_PORT),
-		svc:        cfg.GetVal(T.SL_APP_NAME),
-		targets:    targets,
-		batchTime:  batchTime,
-		metricTime: metricTime,
+		loglvl:    lvl,
+		host:      cfg.GetVal(T.SL_HTTP_IP) + cfg.GetVal(T.SL_HTTP_PORT),
+		svc:       cfg.GetVal(T.SL_APP_NAME),
+		targets:   targets,
+		batchTime: batchTime,
+		logbuf:    &strings.Builder{},
+		mu:        &sync.Mutex{},
 	}
 }
 
+// Ctx gives the logger writing to the same targets with request id of ctx, the same logger if there is no id
+func (l *LogFprintf) Ctx(ctx context.Context) T.ILog {
+	rid := T.RequestID(ctx)
+	if len(rid) == 0 {
+		return l
+	}
+	child := *l
+	child.rid = rid
+	return &child
+}
+
 func (l *LogFprintf) writeBatch() {
 	l.mu.Lock()
 	if l.logbuf.Len() != 0 {
@@ -129,20 +141,6 @@ func (l *LogFprintf) Start() func() {
 			}
 		}()
 	}
-	if l.metricTime != 0 {
-		wg.Add(1)
-		go func() {
-			for {
-				select {
-				case <-time.After(l.metricTime):
-					l.logMetrics(l.getMetrics())
-				case <-ctx.Done():
-					wg.Done()
-					return
-				}
-			}
-		}()
-	}
 	return func() {
 		ctxCancel()
 		wg.Wait()
@@ -153,16 +151,35 @@ func replaceEOL(str string) string {
 	return strings.ReplaceAll(str, "\n", "\t")
 }
 
-func (l *LogFprintf) logMessage(lvl, host, svc, mess string) {
+// escapeJSON keeps the line valid JSON when messages have quotes or user input like paths and agents
+func escapeJSON(str string) string {
+	b, _ := json.Marshal(replaceEOL(str))
+	return string(b[1 : len(b)-1])
+}
+
+func (l *LogFprintf) logMessage(lvl, host, svc, mess string, fields ...T.LogField) {
 	timenow := time.Now().Format(time.RFC3339Nano)
-	formatstr := `{"T":"%s","L":"%s","H":"%s","S":"%s","M":"%s"}` + "\n"
+	var line string
+	if len(l.rid) == 0 {
+		line = fmt.Sprintf(`{"T":"%s","L":"%s","H":"%s","S":"%s","M":"%s"`, timenow, lvl, host, svc, escapeJSON(mess))
+	} else {
+		line = fmt.Sprintf(`{"T":"%s","L":"%s","H":"%s","S":"%s","R":"%s","M":"%s"`, timenow, lvl, host, svc, escapeJSON(l.rid), escapeJSON(mess))
+	}
+	for _, field := range fields {
+		val, err := json.Marshal(field.Val)
+		if err != nil {
+			val, _ = json.Marshal(fmt.Sprint(field.Val))
+		}
+		line += fmt.Sprintf(`,"%s":%s`, escapeJSON(field.Key), val)
+	}
+	line += "}\n"
 	if l.batchTime == 0 {
 		for _, point := range l.targets {
-			fmt.Fprintf(point, formatstr, timenow, lvl, host, svc, replaceEOL(mess))
+			fmt.Fprint(point, line)
 		}
 	} else {
 		l.mu.Lock()
-		l.logbuf.WriteString(fmt.Sprintf(formatstr, timenow, lvl, host, svc, mess))
+		l.logbuf.WriteString(line)
 		l.mu.Unlock()
 	}
 }
@@ -185,6 +202,12 @@ func (l *LogFprintf) LogInfo(format string, v ...any) {
 	}
 }
 
+func (l *LogFprintf) LogInfoFields(mess string, fields ...T.LogField) {
+	if l.loglvl <= Info {
+		l.logMessage(StrInfo, l.host, l.svc, mess, fields...)
+	}
+}
+
 func (l *LogFprintf) LogWarn(format string, v ...any) {
 	if l.loglvl <= Warn {
 		l.logMessage(StrWarn, l.host, l.svc, fmt.Sprintf(format, v...))
@@ -221,53 +244,6 @@ func getLineNumber() string {
 	return ""
 }
 
-func (l *LogFprintf) logMetrics(msg string) {
-	l.logMessage("METRIC", l.host, l.svc, msg)
-}
-
-func (l *LogFprintf) medianBucket(h *metrics.Float64Histogram) float64 {
-	total := uint64(0)
-	for _, count := range h.Counts {
-		total += count
-	}
-	thresh := total / 2
-	total = 0
-	for i, count := range h.Counts {
-		total += count
-		if total >= thresh {
-			return h.Buckets[i]
-		}
-	}
-	panic("log module: should not happen")
-}
-
-func (l *LogFprintf) getMetrics() string {
-	descs := metrics.All()
-	samples := make([]metrics.Sample, len(descs))
-	for i := range samples {
-		samples[i].Name = descs[i].Name
-	}
-	metrics.Read(samples)
-	var metr strings.Builder
-	metr.WriteString("\n")
-	for _, sample := range samples {
-		name, value := sample.Name, sample.Value
-		switch value.Kind() {
-		case metrics.KindUint64:
-			fmt.Fprintf(&metr, "%s: %d\n", name, value.Uint64())
-		case metrics.KindFloat64:
-			fmt.Fprintf(&metr, "%s: %f\n", name, value.Float64())
-		case metrics.KindFloat64Histogram:
-			fmt.Fprintf(&metr, "%s: %f\n", name, l.medianBucket(value.Float64Histogram()))
-		case metrics.KindBad:
-			l.LogPanic(fmt.Errorf("%s", "bad value kind in log module using runtime/metrics package!"))
-		default:
-			fmt.Fprintf(&metr, "%s: unexpected metric Kind: %v\n", name, value.Kind())
-		}
-	}
-	return metr.String()
-}
-
 func ASSERT_(cond bool, msg string) {
 	if !cond {
 		fmt.Fprintf(os.Stdout, "\n[ASSERT] %s %s\n\n", getLineNumber(), msg)
@@ -0,0 +1,46 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	T "shortlink2/internal/types"
+	"testing"
+)
+
+type cfgMap map[string]string
+
+func (c cfgMap) GetVal(key string) string { return c[key] }
+func (c cfgMap) Parse() T.ICfg            { return c }
+func (c cfgMap) Validate() error          { return nil }
+
+func TestLogInfoFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogFprintf(cfgMap{T.SL_LOG_LEVEL: StrInfo, T.SL_APP_NAME: "test"}, 0, &buf)
+	ctx := T.WithRequestID(context.Background(), "rid1")
+	l.Ctx(ctx).LogInfoFields("access",
+		T.LogField{Key: "path", Val: `/a"b`},
+		T.LogField{Key: "status", Val: 302},
+		T.LogField{Key: "duration", Val: 0.5},
+	)
+	line := map[string]any{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("not JSON: %v: %s", err, buf.String())
+	}
+	want := map[string]any{"L": StrInfo, "S": "test", "R": "rid1", "M": "access", "path": `/a"b`, "status": 302.0, "duration": 0.5}
+	for key, val := range want {
+		if line[key] != val {
+			t.Errorf("%s = %v, want %v", key, line[key], val)
+		}
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogFprintf(cfgMap{T.SL_LOG_LEVEL: StrWarn}, 0, &buf)
+	l.LogInfo("%s", "skipped")
+	l.LogInfoFields("skipped", T.LogField{Key: "k", Val: 1})
+	if buf.Len() != 0 {
+		t.Fatalf("info is logged at warn level: %s", buf.String())
+	}
+}
@@ -0,0 +1,300 @@
+/*
+	Prometheus metrics module:
+
+- text exposition format 0.0.4, no client library
+- counters and histograms with labels, gauges without
+- Go runtime metrics from "runtime/metrics" read on every scrape
+- families are written in the order of registration, series sorted by label values
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"runtime/metrics"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType of the exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are latency buckets in seconds, the same as the Prometheus client ones
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: []family{}}
+}
+
+func (reg *Registry) add(f family) {
+	reg.mu.Lock()
+	reg.families = append(reg.families, f)
+	reg.mu.Unlock()
+}
+
+// Write dumps all families, it is the body of /metrics
+func (reg *Registry) Write(w io.Writer) error {
+	reg.mu.Lock()
+	families := append([]family{}, reg.families...)
+	reg.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labelPairs gives {a="1",b="2"} with extra pairs like le appended, empty for no labels
+func labelPairs(names, vals []string, extra ...string) string {
+	if len(names)+len(extra) == 0 {
+		return ""
+	}
+	esc := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+esc.Replace(vals[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+esc.Replace(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series keeps values of label sets, key is label values joined by \xff
+type series[V any] struct {
+	mu   sync.Mutex
+	vals map[string]V
+	make func() V
+}
+
+func (s *series[V]) get(labels []string, vals []string) V {
+	if len(vals) != len(labels) {
+		panic(fmt.Sprintf("metrics: %d label values for labels %v", len(vals), labels))
+	}
+	key := strings.Join(vals, "\xff")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.vals[key]
+	if !ok {
+		v = s.make()
+		s.vals[key] = v
+	}
+	return v
+}
+
+// sorted gives a snapshot of label values and series
+func (s *series[V]) sorted() ([][]string, []V) {
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.vals))
+	for key := range s.vals {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	labels, vals := make([][]string, len(keys)), make([]V, len(keys))
+	for i, key := range keys {
+		labels[i], vals[i] = strings.Split(key, "\xff"), s.vals[key]
+	}
+	s.mu.Unlock()
+	return labels, vals
+}
+
+type Counter struct {
+	name, help string
+	labels     []string
+	series     series[*atomic.Uint64]
+}
+
+// Counter registers a counter, Inc and Add take values of the labels in the same order
+func (reg *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels}
+	c.series = series[*atomic.Uint64]{vals: map[string]*atomic.Uint64{}, make: func() *atomic.Uint64 { return &atomic.Uint64{} }}
+	reg.add(c)
+	return c
+}
+
+func (c *Counter) Inc(vals ...string) {
+	c.Add(1, vals...)
+}
+
+func (c *Counter) Add(n uint64, vals ...string) {
+	c.series.get(c.labels, vals).Add(n)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	labels, vals := c.series.sorted()
+	if len(c.labels) == 0 && len(vals) == 0 { // counters without labels are always shown
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for i, v := range vals {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, labelPairs(c.labels, labels[i]), v.Load())
+	}
+}
+
+type Gauge struct {
+	name, help string
+	val        atomic.Int64
+}
+
+func (reg *Registry) Gauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	reg.add(g)
+	return g
+}
+
+func (g *Gauge) Inc()        { g.val.Add(1) }
+func (g *Gauge) Dec()        { g.val.Add(-1) }
+func (g *Gauge) Set(v int64) { g.val.Store(v) }
+
+func (g *Gauge) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %d\n", g.name, g.val.Load())
+}
+
+type histogramSeries struct {
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative, the last one is +Inf
+	sum    float64
+}
+
+type Histogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	series     series[*histogramSeries]
+}
+
+// Histogram registers a histogram with sorted upper bounds of buckets, +Inf is implicit
+func (reg *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: append([]float64{}, buckets...)}
+	sort.Float64s(h.buckets)
+	h.series = series[*histogramSeries]{vals: map[string]*histogramSeries{}, make: func() *histogramSeries {
+		return &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+	}}
+	reg.add(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, vals ...string) {
+	s := h.series.get(h.labels, vals)
+	i := sort.SearchFloat64s(h.buckets, v) // first bucket with bound >= v
+	s.mu.Lock()
+	s.counts[i]++
+	s.sum += v
+	s.mu.Unlock()
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	labels, vals := h.series.sorted()
+	for i, s := range vals {
+		s.mu.Lock()
+		counts, sum := append([]uint64{}, s.counts...), s.sum
+		s.mu.Unlock()
+		total := uint64(0)
+		for j, count := range counts {
+			total += count
+			bound := math.Inf(1)
+			if j < len(h.buckets) {
+				bound = h.buckets[j]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, labels[i], "le", formatFloat(bound)), total)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelPairs(h.labels, labels[i]), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, labels[i]), total)
+	}
+}
+
+// runtimeMetrics exports uint64 and float64 samples of "runtime/metrics" as go_*,
+// runtime histograms are skipped, their hundreds of buckets are too much for a scrape
+type runtimeMetrics struct {
+	descs   []metrics.Description
+	names   []string
+	samples []metrics.Sample
+	mu      sync.Mutex
+}
+
+// Runtime registers Go runtime metrics, /gc/heap/allocs:bytes goes as go_gc_heap_allocs_bytes_total
+func (reg *Registry) Runtime() {
+	rm := &runtimeMetrics{}
+	seen := map[string]bool{}
+	for _, desc := range metrics.All() {
+		if (desc.Kind != metrics.KindUint64) && (desc.Kind != metrics.KindFloat64) {
+			continue
+		}
+		name := runtimeName(desc.Name, desc.Cumulative)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		rm.descs = append(rm.descs, desc)
+		rm.names = append(rm.names, name)
+		rm.samples = append(rm.samples, metrics.Sample{Name: desc.Name})
+	}
+	reg.add(rm)
+}
+
+func runtimeName(name string, cumulative bool) string {
+	var b strings.Builder
+	b.WriteString("go_")
+	for _, c := range strings.TrimPrefix(name, "/") {
+		if ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z')) || ((c >= '0') && (c <= '9')) {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	if cumulative {
+		b.WriteString("_total")
+	}
+	return b.String()
+}
+
+func (rm *runtimeMetrics) write(w *bufio.Writer) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	metrics.Read(rm.samples)
+	for i, sample := range rm.samples {
+		kind := "gauge"
+		if rm.descs[i].Cumulative {
+			kind = "counter"
+		}
+		switch sample.Value.Kind() {
+		case metrics.KindUint64:
+			writeHeader(w, rm.names[i], rm.descs[i].Description, kind)
+			fmt.Fprintf(w, "%s %d\n", rm.names[i], sample.Value.Uint64())
+		case metrics.KindFloat64:
+			writeHeader(w, rm.names[i], rm.descs[i].Description, kind)
+			fmt.Fprintf(w, "%s %s\n", rm.names[i], formatFloat(sample.Value.Float64()))
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	T "shortlink2/internal/types"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	Rules file, one rule per line, the first matching rule wins, links matching no rule are allowed:
+
+	# action  kind  value
+	allow     host  intranet.example.com
+	deny      host  evil.com
+	deny      host  *.phish.net      # phish.net and all subdomains, single * matches any host
+	deny      cidr  203.0.113.0/24   # IP literal hosts only
+	deny      path  ^/wp-admin       # regex on the link path
+
+	Private, loopback, link-local, unspecified, CGNAT and other reserved IP literals and localhost names are denied
+	after the rules unless SL_POLICY_PRIVATE=allow, so an allow rule can still open one of them.
+*/
+
+var _ T.IPolicy = (*PolicyFile)(nil)
+
+type rule struct {
+	allow bool
+	kind  string
+	host  string
+	cidr  *net.IPNet
+	path  *regexp.Regexp
+	line  int
+}
+
+type PolicyFile struct {
+	fname      string
+	reloadTime time.Duration
+	private    bool
+	rules      atomic.Pointer[[]rule]
+	mtime      time.Time
+	log        T.ILog
+}
+
+func NewPolicyFile(cfg T.ICfg, log T.ILog) *PolicyFile {
+	p := &PolicyFile{
+		fname:      cfg.GetVal(T.SL_POLICY_FILE),
+		reloadTime: 10 * time.Second,
+		private:    false,
+		log:        log,
+	}
+	if reloadTime, err := time.ParseDuration(cfg.GetVal(T.SL_POLICY_RELOAD)); (err == nil) && (reloadTime >= 0) {
+		p.reloadTime = reloadTime
+	} else {
+		log.LogError(fmt.Errorf("%s: %s=%s", "NewPolicyFile(): bad reload period, using 10s", T.SL_POLICY_RELOAD, cfg.GetVal(T.SL_POLICY_RELOAD)))
+	}
+	switch cfg.GetVal(T.SL_POLICY_PRIVATE) {
+	case "deny":
+	case "allow":
+		p.private = true
+	default:
+		log.LogError(fmt.Errorf("%s: %s=%s", "NewPolicyFile(): bad private hosts policy, using deny", T.SL_POLICY_PRIVATE, cfg.GetVal(T.SL_POLICY_PRIVATE)))
+	}
+	p.rules.Store(&[]rule{})
+	if len(p.fname) != 0 {
+		p.reload()
+	}
+	return p
+}
+
+// Check takes the canonical link and returns ErrForbidden if the policy denies it
+func (p *PolicyFile) Check(link string) error {
+	u, err := url.Parse(link)
+	if err != nil {
+		return fmt.Errorf("%w: %s", T.ErrInvalidLink, err.Error())
+	}
+	host := strings.ToLower(u.Hostname())
+	ip := net.ParseIP(host)
+	for _, r := range *p.rules.Load() {
+		if !r.match(host, ip, u.EscapedPath()) {
+			continue
+		}
+		if r.allow {
+			return nil
+		}
+		return fmt.Errorf("%w: %s denied by policy rule at line %d", T.ErrForbidden, host, r.line)
+	}
+	if !p.private && isPrivate(host, ip) {
+		return fmt.Errorf("%w: %s is a private or local host", T.ErrForbidden, host)
+	}
+	return nil
+}
+
+func (r rule) match(host string, ip net.IP, path string) bool {
+	switch r.kind {
+	case "host":
+		switch {
+		case r.host == "*":
+			return true
+		case strings.HasPrefix(r.host, "*."):
+			return (host == r.host[2:]) || strings.HasSuffix(host, r.host[1:])
+		default:
+			return host == r.host
+		}
+	case "cidr":
+		return (ip != nil) && r.cidr.Contains(ip)
+	case "path":
+		return r.path.MatchString(path)
+	}
+	return false
+}
+
+// reserved are special purpose ranges of RFC 6890 not covered by netip.Addr methods, NAT64 and
+// 6to4 ranges are here because they embed IPv4 addresses
+var reserved = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// isPrivate does not resolve names, numeric hosts like 2130706433 or 0x7f.1 are treated as private
+// because browsers read them as IPv4
+func isPrivate(host string, ip net.IP) bool {
+	if addr, ok := netip.AddrFromSlice(ip); ok {
+		addr = addr.Unmap()
+		if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsMulticast() || addr.IsUnspecified() {
+			return true
+		}
+		for _, prefix := range reserved {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	host = strings.TrimSuffix(host, ".")
+	if (host == "localhost") || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	last := host[strings.LastIndexByte(host, '.')+1:]
+	if strings.HasPrefix(last, "0x") {
+		return true
+	}
+	return (len(last) != 0) && (strings.Trim(last, "0123456789") == "")
+}
+
+func parseRules(fname string) ([]rule, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules := []rule{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: %s", n, "rule must be: allow|deny host|cidr|path value")
+		}
+		r := rule{kind: fields[1], line: n}
+		switch fields[0] {
+		case "allow":
+			r.allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %q", n, fields[0])
+		}
+		switch r.kind {
+		case "host":
+			r.host = strings.ToLower(fields[2])
+		case "cidr":
+			if _, r.cidr, err = net.ParseCIDR(fields[2]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+		case "path":
+			if r.path, err = regexp.Compile(fields[2]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown kind %q", n, r.kind)
+		}
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+// reload keeps the previous rules if the file is broken, so a bad edit does not open the policy
+func (p *PolicyFile) reload() {
+	info, err := os.Stat(p.fname)
+	if err != nil {
+		p.log.LogError(fmt.Errorf("%s: %w", "PolicyFile.reload(): unable to stat rules file", err))
+		return
+	}
+	if info.ModTime().Equal(p.mtime) {
+		return
+	}
+	rules, err := parseRules(p.fname)
+	if err != nil {
+		p.log.LogError(fmt.Errorf("%s %s: %w", "PolicyFile.reload(): rules file is broken, keeping old rules", p.fname, err))
+		return
+	}
+	p.mtime = info.ModTime()
+	p.rules.Store(&rules)
+	p.log.LogInfo("policy rules loaded: %d from %s", len(rules), p.fname)
+}
+
+// Start polls the rules file modification time every reloadTime
+func (p *PolicyFile) Start() func() {
+	var wg sync.WaitGroup
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	if (len(p.fname) != 0) && (p.reloadTime != 0) {
+		wg.Add(1)
+		go func() {
+			ticker := time.NewTicker(p.reloadTime)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					p.reload()
+				case <-ctx.Done():
+					wg.Done()
+					return
+				}
+			}
+		}()
+	}
+	return func() {
+		ctxCancel()
+		wg.Wait()
+	}
+}
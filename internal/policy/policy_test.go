@@ -0,0 +1,154 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	L "shortlink2/internal/log"
+	T "shortlink2/internal/types"
+	"testing"
+	"time"
+)
+
+type cfgMap map[string]string
+
+func (c cfgMap) GetVal(key string) string { return c[key] }
+func (c cfgMap) Parse() T.ICfg            { return c }
+func (c cfgMap) Validate() error          { return nil }
+
+const testRules = `# action kind value
+allow host intranet.example.com
+allow cidr 10.1.0.0/16     # opens one private range
+deny  host evil.com
+deny  host *.phish.net
+deny  cidr 198.51.0.0/16
+deny  path ^/wp-admin
+`
+
+// testPolicy writes the rules file with the mtime and loads it
+func testPolicy(t *testing.T, rules, private string) (*PolicyFile, string) {
+	fname := filepath.Join(t.TempDir(), "rules.txt")
+	writeRules(t, fname, rules, time.Now().Add(-time.Hour))
+	cfg := cfgMap{T.SL_LOG_LEVEL: "NOLOG", T.SL_POLICY_FILE: fname, T.SL_POLICY_RELOAD: "0", T.SL_POLICY_PRIVATE: private}
+	return NewPolicyFile(cfg, L.NewLogFprintf(cfg, 0)), fname
+}
+
+func writeRules(t *testing.T, fname, rules string, mtime time.Time) {
+	if err := os.WriteFile(fname, []byte(rules), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(fname, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p, _ := testPolicy(t, testRules, "deny")
+	tests := []struct {
+		link    string
+		allowed bool
+	}{
+		{"http://lib.ru/PROZA/", true},
+		{"http://evil.com/", false},
+		{"http://sub.evil.com/", true}, // host rules without * match the host only
+		{"http://phish.net/", false},
+		{"http://a.b.phish.net/", false},
+		{"http://notphish.net/", true},
+		{"http://lib.ru/wp-admin/setup.php", false},
+		{"http://lib.ru/blog/wp-admin", true},
+		{"http://198.51.7.7/", false},
+		{"http://[::ffff:198.51.7.7]/", false},
+		{"http://intranet.example.com/", true},
+		{"http://10.1.2.3/", true}, // allow rules win over the private block
+		{"http://[::ffff:10.1.2.3]/", true},
+		{"http://10.2.0.1/", false},
+		{"http://127.0.0.1/", false},
+		{"http://[::1]/", false},
+		{"http://[::ffff:127.0.0.1]/", false}, // IPv4-mapped IPv6
+		{"http://[::ffff:192.168.0.1]:8080/", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://100.64.0.1/", false}, // CGNAT
+		{"http://0.0.0.0/", false},
+		{"http://[fe80::1]/", false},
+		{"http://[fc00::1]/", false},
+		{"http://[64:ff9b::7f00:1]/", false}, // NAT64 of 127.0.0.1
+		{"http://[2002:7f00:1::]/", false},   // 6to4 of 127.0.0.1
+		{"http://localhost/", false},
+		{"http://api.localhost./", false},
+		{"http://2130706433/", false},   // decimal 127.0.0.1
+		{"http://0177.0.0.1/", false},   // octal
+		{"http://017700000001/", false}, // octal
+		{"http://0x7f.1/", false},       // hex
+		{"http://0x7f000001/", false},
+		{"http://8.8.8.8/", true},
+		{"http://[2606:4700::1111]/", true},
+		{"http://1password.com/", true},
+	}
+	for _, tt := range tests {
+		err := p.Check(tt.link)
+		if (err == nil) != tt.allowed {
+			t.Errorf("Check(%q) = %v, allowed %v", tt.link, err, tt.allowed)
+		}
+		if (err != nil) && !errors.Is(err, T.ErrForbidden) {
+			t.Errorf("Check(%q) = %v, want ErrForbidden", tt.link, err)
+		}
+	}
+}
+
+func TestCheckPrivateAllowed(t *testing.T) {
+	p, _ := testPolicy(t, testRules, "allow")
+	for link, allowed := range map[string]bool{"http://127.0.0.1/": true, "http://localhost/": true, "http://2130706433/": true, "http://evil.com/": false} {
+		if err := p.Check(link); (err == nil) != allowed {
+			t.Errorf("Check(%q) = %v, allowed %v", link, err, allowed)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	for _, rules := range []string{
+		"allow host",
+		"permit host lib.ru",
+		"deny port 80",
+		"deny cidr 10.0.0.0/33",
+		"deny path ^/(a",
+		"deny host a.ru b.ru",
+	} {
+		fname := filepath.Join(t.TempDir(), "rules.txt")
+		writeRules(t, fname, rules, time.Now())
+		if _, err := parseRules(fname); err == nil {
+			t.Errorf("rules %q are parsed", rules)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	p, fname := testPolicy(t, "deny host evil.com\n", "deny")
+	if err := p.Check("http://evil.com/"); err == nil {
+		t.Fatal("the rules are not loaded")
+	}
+	// a broken file keeps the old rules, whatever its mtime is
+	writeRules(t, fname, "deny host\nallow host evil.com\n", time.Now().Add(-time.Minute))
+	p.reload()
+	if err := p.Check("http://evil.com/"); err == nil {
+		t.Fatal("a broken rules file drops the old rules")
+	}
+	// the file is reloaded only when its mtime changes
+	writeRules(t, fname, "deny host lib.ru\n", p.mtime)
+	p.reload()
+	if err := p.Check("http://lib.ru/"); err != nil {
+		t.Fatalf("the file is reloaded without mtime change: %v", err)
+	}
+	writeRules(t, fname, "deny host lib.ru\n", time.Now())
+	p.reload()
+	if err := p.Check("http://evil.com/"); err != nil {
+		t.Fatalf("the old rules are kept after a good reload: %v", err)
+	}
+	if err := p.Check("http://lib.ru/"); err == nil {
+		t.Fatal("the new rules are not loaded")
+	}
+	os.Remove(fname)
+	p.reload()
+	if err := p.Check("http://lib.ru/"); err == nil {
+		t.Fatal("a missing rules file drops the rules")
+	}
+}
@@ -0,0 +1,111 @@
+package svc
+
+import (
+	"fmt"
+	G "shortlink2/internal/hashgen"
+	T "shortlink2/internal/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// reservedAliases can not be used as aliases because they shadow server routes; static files are
+// kept away by the charset, which has no '.'
+var reservedAliases = []string{"load", "save", "delete", "stats", "api", "oapi", "metrics", "healthz", "readyz"}
+
+type aliasPolicy struct {
+	charset  string
+	minlen   int
+	maxlen   int
+	reserved map[string]struct{}
+	prefixes map[string]string // tenant -> alias prefix
+	order    []string          // tenants by prefix length, longest first
+}
+
+func newAliasPolicy(cfg T.ICfg, log T.ILog) *aliasPolicy {
+	ap := &aliasPolicy{
+		charset:  cfg.GetVal(T.SL_ALIAS_CHARSET),
+		minlen:   4,
+		maxlen:   32,
+		reserved: make(map[string]struct{}, len(reservedAliases)),
+		prefixes: map[string]string{},
+	}
+	if err := G.CheckAlphabet(ap.charset); err != nil {
+		log.LogError(fmt.Errorf("%s: %w", "newAliasPolicy(): bad alias charset, using default", err))
+		ap.charset = "0123456789abcdefghijklmnopqrstuvwxyz-_"
+	}
+	minlen, err1 := strconv.Atoi(cfg.GetVal(T.SL_ALIAS_MINLEN))
+	maxlen, err2 := strconv.Atoi(cfg.GetVal(T.SL_ALIAS_MAXLEN))
+	if (err1 == nil) && (err2 == nil) && (minlen > 0) && (minlen <= maxlen) && (maxlen <= 128) {
+		ap.minlen, ap.maxlen = minlen, maxlen
+	} else {
+		log.LogError(fmt.Errorf("%s: %s..%s", "newAliasPolicy(): bad alias length range, using 4..32",
+			cfg.GetVal(T.SL_ALIAS_MINLEN), cfg.GetVal(T.SL_ALIAS_MAXLEN)))
+	}
+	for _, word := range reservedAliases {
+		ap.reserved[word] = struct{}{}
+	}
+	for _, word := range strings.Split(cfg.GetVal(T.SL_ALIAS_RESERVED), ",") {
+		if word = strings.TrimSpace(word); len(word) != 0 {
+			ap.reserved[strings.ToLower(word)] = struct{}{}
+		}
+	}
+	for _, pair := range strings.Split(cfg.GetVal(T.SL_TENANT_PREFIX), ",") {
+		if pair = strings.TrimSpace(pair); len(pair) == 0 {
+			continue
+		}
+		tenant, prefix, ok := strings.Cut(pair, ":")
+		if !ok || (len(prefix) == 0) || (strings.Trim(prefix, ap.charset) != "") {
+			log.LogError(fmt.Errorf("%s: %s", "newAliasPolicy(): bad tenant prefix, skipped", pair))
+			continue
+		}
+		ap.prefixes[tenant] = prefix
+	}
+	for tenant := range ap.prefixes {
+		ap.order = append(ap.order, tenant)
+	}
+	sort.Slice(ap.order, func(i, j int) bool {
+		pi, pj := ap.prefixes[ap.order[i]], ap.prefixes[ap.order[j]]
+		if len(pi) != len(pj) {
+			return len(pi) > len(pj)
+		}
+		return ap.order[i] < ap.order[j]
+	})
+	return ap
+}
+
+// withPrefix adds the tenant prefix to the alias, the longest prefix of the result must be the tenant
+// one, so with nested prefixes like a- and a-b- tenants can not take aliases of each other
+func (ap *aliasPolicy) withPrefix(alias, tenant string) (string, error) {
+	if prefix := ap.prefixes[tenant]; !strings.HasPrefix(alias, prefix) {
+		alias = prefix + alias
+	}
+	for _, t := range ap.order {
+		if prefix := ap.prefixes[t]; strings.HasPrefix(alias, prefix) {
+			if t != tenant {
+				return "", fmt.Errorf("%w: prefix %s belongs to another tenant", T.ErrAliasInvalid, prefix)
+			}
+			break
+		}
+	}
+	return alias, nil
+}
+
+func (ap *aliasPolicy) check(alias string) error {
+	if (len(alias) < ap.minlen) || (len(alias) > ap.maxlen) {
+		return fmt.Errorf("%w: length must be in range %d..%d", T.ErrAliasInvalid, ap.minlen, ap.maxlen)
+	}
+	for i := 0; i < len(alias); i++ {
+		if strings.IndexByte(ap.charset, alias[i]) < 0 {
+			return fmt.Errorf("%w: char %q is not allowed", T.ErrAliasInvalid, alias[i])
+		}
+	}
+	if _, ok := ap.reserved[strings.ToLower(alias)]; ok {
+		return fmt.Errorf("%w: %s is reserved", T.ErrAliasInvalid, alias)
+	}
+	return nil
+}
+
+func (ap *aliasPolicy) pattern() string {
+	return fmt.Sprintf("[%s]{%d,%d}", strings.ReplaceAll(ap.charset, "-", `\-`), ap.minlen, ap.maxlen)
+}
@@ -0,0 +1,81 @@
+package svc
+
+import (
+	"errors"
+	L "shortlink2/internal/log"
+	T "shortlink2/internal/types"
+	"testing"
+)
+
+func testAliasPolicy(charset string) *aliasPolicy {
+	cfg := cfgMap{
+		T.SL_LOG_LEVEL:      "NOLOG",
+		T.SL_ALIAS_CHARSET:  charset,
+		T.SL_ALIAS_MINLEN:   "4",
+		T.SL_ALIAS_MAXLEN:   "12",
+		T.SL_ALIAS_RESERVED: "Admin, login",
+		T.SL_TENANT_PREFIX:  "acme:a-,acmebeta:a-b-,beta:b-",
+	}
+	return newAliasPolicy(cfg, L.NewLogFprintf(cfg, 0))
+}
+
+func TestAliasCheck(t *testing.T) {
+	ap := testAliasPolicy("0123456789abcdefghijklmnopqrstuvwxyz-_")
+	tests := []struct {
+		alias string
+		ok    bool
+	}{
+		{"my-link_1", true},
+		{"abc", false},           // too short
+		{"abcdefghijklm", false}, // too long
+		{"MyLink", false},        // upper case is not in the charset
+		{"index.html", false},    // '.' is never in the charset, static files are safe
+		{"my link", false},
+		{"healthz", false},
+		{"ADMIN", false}, // reserved words of the config ignore case
+		{"login", false},
+	}
+	for _, tt := range tests {
+		err := ap.check(tt.alias)
+		if (err == nil) != tt.ok {
+			t.Errorf("check(%q) = %v", tt.alias, err)
+		}
+		if (err != nil) && !errors.Is(err, T.ErrAliasInvalid) {
+			t.Errorf("check(%q) = %v, want ErrAliasInvalid", tt.alias, err)
+		}
+	}
+}
+
+func TestAliasCharset(t *testing.T) {
+	for _, charset := range []string{"", "abc.", "abca", "a"} {
+		if ap := testAliasPolicy(charset); ap.charset != "0123456789abcdefghijklmnopqrstuvwxyz-_" {
+			t.Errorf("bad charset %q is used", charset)
+		}
+	}
+	if ap := testAliasPolicy("ABCD"); ap.check("ABCD") != nil {
+		t.Error("alias of a custom charset is rejected")
+	}
+}
+
+func TestAliasPrefix(t *testing.T) {
+	ap := testAliasPolicy("0123456789abcdefghijklmnopqrstuvwxyz-_")
+	tests := []struct {
+		alias, tenant, want string
+		ok                  bool
+	}{
+		{"link", "acme", "a-link", true},
+		{"a-link", "acme", "a-link", true}, // the prefix is not doubled
+		{"link", "acmebeta", "a-b-link", true},
+		{"b-link", "acme", "a-b-link", false}, // a- + b-link is a prefix of acmebeta
+		{"a-b-link", "acme", "", false},
+		{"a-link", "beta", "b-a-link", true},
+		{"a-link", "", "", false}, // keys without tenant can not take tenant prefixes
+		{"link", "", "link", true},
+	}
+	for _, tt := range tests {
+		got, err := ap.withPrefix(tt.alias, tt.tenant)
+		if (err == nil) != tt.ok || (tt.ok && (got != tt.want)) {
+			t.Errorf("withPrefix(%q, %q) = %q, %v, want %q", tt.alias, tt.tenant, got, err, tt.want)
+		}
+	}
+}
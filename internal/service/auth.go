@@ -0,0 +1,99 @@
+package svc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	G "shortlink2/internal/hashgen"
+	T "shortlink2/internal/types"
+	"strings"
+	"time"
+)
+
+var _ T.ISvcAuth = (*SvcAuth)(nil)
+
+// tokenPrefix marks shortlink2 keys, so leaked ones are easy to find by secret scanners
+const tokenPrefix = "sl2_"
+
+// SvcAuth issues API keys as sl2_<id>_<secret>, only sha256 of the whole token is stored
+type SvcAuth struct {
+	db       T.IDB
+	log      T.ILog
+	ids      T.IHashGen
+	secrets  T.IHashGen
+	anonSave bool
+}
+
+func NewSvcAuth(db T.IDB, log T.ILog, cfg T.ICfg) *SvcAuth {
+	anonSave := false
+	switch cfg.GetVal(T.SL_AUTH_ANONSAVE) {
+	case "false":
+	case "true":
+		anonSave = true
+	default:
+		log.LogError(fmt.Errorf("%s: %s=%s", "NewSvcAuth(): bad anonymous save mode, using false", T.SL_AUTH_ANONSAVE, cfg.GetVal(T.SL_AUTH_ANONSAVE)))
+	}
+	return &SvcAuth{
+		db:       db,
+		log:      log,
+		ids:      G.NewHashRandom(G.Base36, 8),
+		secrets:  G.NewHashRandom(G.Base62, 32),
+		anonSave: anonSave,
+	}
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *SvcAuth) Authenticate(ctx context.Context, token string) (T.APIKey, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return T.APIKey{}, fmt.Errorf("%w: %s", T.ErrUnauthorized, "malformed API key")
+	}
+	key, err := a.db.LoadAPIKey(ctx, tokenHash(token))
+	switch {
+	case errors.Is(err, T.ErrNotFound):
+		return T.APIKey{}, fmt.Errorf("%w: %s", T.ErrUnauthorized, "unknown API key")
+	case err != nil:
+		return T.APIKey{}, err
+	case !key.Revoked.IsZero():
+		return T.APIKey{}, fmt.Errorf("%w: %s", T.ErrUnauthorized, "API key is revoked")
+	}
+	return key, nil
+}
+
+// CreateKey returns the token, it is shown once and can not be restored from the db
+func (a *SvcAuth) CreateKey(ctx context.Context, name, tenant string, admin bool) (string, T.APIKey, error) {
+	id, secret := a.ids.Hash("", 0), a.secrets.Hash("", 0)
+	if (len(id) == 0) || (len(secret) == 0) {
+		return "", T.APIKey{}, fmt.Errorf("%s", "SvcAuth.CreateKey(): unable to read random source")
+	}
+	token := tokenPrefix + id + "_" + secret
+	key := T.APIKey{
+		ID:      id,
+		Name:    name,
+		Tenant:  tenant,
+		Hash:    tokenHash(token),
+		Admin:   admin,
+		Created: time.Now(),
+	}
+	if err := a.db.SaveAPIKey(ctx, key); err != nil {
+		return "", T.APIKey{}, err
+	}
+	return token, key, nil
+}
+
+func (a *SvcAuth) RevokeKey(ctx context.Context, id string) error {
+	return a.db.RevokeAPIKey(ctx, id, time.Now())
+}
+
+func (a *SvcAuth) ListKeys(ctx context.Context) ([]T.APIKey, error) {
+	return a.db.ListAPIKeys(ctx)
+}
+
+func (a *SvcAuth) AnonSave() bool {
+	return a.anonSave
+}
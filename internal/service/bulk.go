@@ -0,0 +1,191 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	F "shortlink2/internal/linkfile"
+	T "shortlink2/internal/types"
+)
+
+var _ T.ISvcBulk = (*SvcBulk)(nil)
+
+const (
+	bulkPage     = 500  // links per db read of export
+	bulkProgress = 5000 // links between progress reports
+)
+
+// SvcBulk streams links as they are stored, imported links skip canonicalization and policy,
+// so data moves between environments unchanged; only admins can reach it. Imports with the fail
+// policy are atomic: the input is spooled to a temp file, then saved in one db transaction
+type SvcBulk struct {
+	db  T.IDB
+	log T.ILog
+}
+
+func NewSvcBulk(db T.IDB, log T.ILog) *SvcBulk {
+	return &SvcBulk{
+		db:  db,
+		log: log,
+	}
+}
+
+func (b *SvcBulk) Export(ctx context.Context, w io.Writer, format string, progress func(T.BulkReport)) (T.BulkReport, error) {
+	report := T.BulkReport{}
+	lw, err := F.NewWriter(w, format)
+	if err != nil {
+		return report, err
+	}
+	for after := ""; ; {
+		pairs, err := b.db.ScanLinks(ctx, after, bulkPage)
+		if err != nil {
+			return report, err
+		}
+		for _, pair := range pairs {
+			if err := lw.Write(pair); err != nil {
+				return report, err
+			}
+			report.Read++
+			if report.Read%bulkProgress == 0 {
+				if err := lw.Flush(); err != nil {
+					return report, err
+				}
+				progress(report)
+			}
+		}
+		if len(pairs) < bulkPage {
+			break
+		}
+		after = pairs[len(pairs)-1].Hash
+	}
+	if err := lw.Close(); err != nil {
+		return report, err
+	}
+	report.Done = true
+	progress(report)
+	return report, nil
+}
+
+// Import saves links one by one with skip and overwrite policies, on error the report tells how far
+// it got; with the fail policy nothing is saved if any hash is taken
+func (b *SvcBulk) Import(ctx context.Context, r io.Reader, format, conflict string, progress func(T.BulkReport)) (T.BulkReport, error) {
+	report := T.BulkReport{}
+	fail := func(err error) (T.BulkReport, error) {
+		report.Failed = report.Read - report.Added - report.Overwritten - report.Skipped
+		report.Error = err.Error()
+		progress(report)
+		return report, err
+	}
+	switch conflict {
+	case T.ConflictSkip, T.ConflictOverwrite, T.ConflictFail:
+	default:
+		return fail(fmt.Errorf("%w: unknown conflict policy %q, use skip, overwrite or fail", T.ErrInvalidLink, conflict))
+	}
+	lr, err := F.NewReader(r, format)
+	if err != nil {
+		return fail(fmt.Errorf("%w: %w", T.ErrInvalidLink, err))
+	}
+	if conflict == T.ConflictFail {
+		if err := b.importAll(ctx, lr, &report, progress); err != nil {
+			return fail(err)
+		}
+		report.Done = true
+		progress(report)
+		return report, nil
+	}
+	for {
+		pair, err := lr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("%w: %w", T.ErrInvalidLink, err))
+		}
+		report.Read++
+		if err := b.importPair(ctx, pair, conflict, &report); err != nil {
+			return fail(err)
+		}
+		if report.Read%bulkProgress == 0 {
+			progress(report)
+		}
+	}
+	report.Done = true
+	progress(report)
+	return report, nil
+}
+
+// importAll spools links to a temp file first, so a slow upload does not hold the db write lock
+func (b *SvcBulk) importAll(ctx context.Context, lr *F.Reader, report *T.BulkReport, progress func(T.BulkReport)) error {
+	spool, err1 := os.CreateTemp("", "shortlink2-import-*.jsonl")
+	if err1 != nil {
+		return fmt.Errorf("%s: %w", "SvcBulk.importAll(): unable to make spool file", err1)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	lw, err2 := F.NewWriter(spool, F.JSONL)
+	if err2 != nil {
+		return err2
+	}
+	for {
+		pair, err := lr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", T.ErrInvalidLink, err)
+		}
+		if err := lw.Write(pair); err != nil {
+			return fmt.Errorf("%s: %w", "SvcBulk.importAll(): unable to write spool file", err)
+		}
+		report.Read++
+		if report.Read%bulkProgress == 0 {
+			progress(*report)
+		}
+	}
+	if err3 := lw.Close(); err3 != nil {
+		return fmt.Errorf("%s: %w", "SvcBulk.importAll(): unable to write spool file", err3)
+	}
+	if _, err4 := spool.Seek(0, io.SeekStart); err4 != nil {
+		return fmt.Errorf("%s: %w", "SvcBulk.importAll(): unable to read spool file", err4)
+	}
+	sr, err5 := F.NewReader(spool, F.JSONL)
+	if err5 != nil {
+		return err5
+	}
+	n, err6 := b.db.SaveLinkPairs(ctx, sr.Next)
+	if err6 != nil {
+		return err6
+	}
+	report.Added = n
+	return nil
+}
+
+func (b *SvcBulk) importPair(ctx context.Context, pair T.DBMess, conflict string, report *T.BulkReport) error {
+	if conflict == T.ConflictOverwrite {
+		_, err1 := b.db.LoadLinkPair(ctx, pair.Hash)
+		if (err1 != nil) && !errors.Is(err1, T.ErrNotFound) {
+			return err1
+		}
+		if err2 := b.db.ReplaceLinkPair(ctx, pair); err2 != nil {
+			return err2
+		}
+		if err1 == nil {
+			report.Overwritten++
+		} else {
+			report.Added++
+		}
+		return nil
+	}
+	err := b.db.SaveLinkPair(ctx, pair)
+	switch {
+	case err == nil:
+		report.Added++
+	case errors.Is(err, T.ErrConflict) && (conflict == T.ConflictSkip):
+		report.Skipped++
+	default:
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	D "shortlink2/internal/db"
+	L "shortlink2/internal/log"
+	T "shortlink2/internal/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+type cfgMap map[string]string
+
+func (c cfgMap) GetVal(key string) string { return c[key] }
+func (c cfgMap) Parse() T.ICfg            { return c }
+func (c cfgMap) Validate() error          { return nil }
+
+// testBulk gives the service over a mock db with link a1 which has one click
+func testBulk(t *testing.T) (*SvcBulk, *D.DBmock) {
+	cfg := cfgMap{T.SL_LOG_LEVEL: "NOLOG"}
+	log := L.NewLogFprintf(cfg, 0)
+	db := D.NewDBmock(cfg, log)
+	ctx := context.Background()
+	if err := db.SaveLinkPair(ctx, T.DBMess{Hash: "a1", Link: "http://old"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveClicks(ctx, []T.DBClick{{Hash: "a1", Time: time.Now(), Referer: "direct", Agent: "curl"}}); err != nil {
+		t.Fatal(err)
+	}
+	return NewSvcBulk(db, log), db
+}
+
+const importFile = `{"hash":"b2","link":"http://b"}
+{"hash":"a1","link":"http://new"}
+{"hash":"c3","link":"http://c"}
+`
+
+func TestImportConflict(t *testing.T) {
+	tests := []struct {
+		name     string
+		conflict string
+		file     string
+		want     T.BulkReport
+		err      error
+		links    map[string]string // hash -> link after import, "" if there is no such hash
+		clicks   int64             // of a1
+	}{
+		{"skip", T.ConflictSkip, importFile,
+			T.BulkReport{Read: 3, Added: 2, Skipped: 1, Done: true}, nil,
+			map[string]string{"a1": "http://old", "b2": "http://b", "c3": "http://c"}, 1},
+		{"overwrite resets clicks", T.ConflictOverwrite, importFile,
+			T.BulkReport{Read: 3, Added: 2, Overwritten: 1, Done: true}, nil,
+			map[string]string{"a1": "http://new", "b2": "http://b", "c3": "http://c"}, 0},
+		{"fail saves nothing", T.ConflictFail, importFile,
+			T.BulkReport{Read: 3, Failed: 3}, T.ErrConflict,
+			map[string]string{"a1": "http://old", "b2": "", "c3": ""}, 1},
+		{"fail on hash twice in file", T.ConflictFail, "{\"hash\":\"b2\",\"link\":\"http://b\"}\n{\"hash\":\"b2\",\"link\":\"http://b\"}\n",
+			T.BulkReport{Read: 2, Failed: 2}, T.ErrConflict,
+			map[string]string{"b2": ""}, 1},
+		{"fail without conflicts", T.ConflictFail, "{\"hash\":\"b2\",\"link\":\"http://b\"}\n{\"hash\":\"c3\",\"link\":\"http://c\"}\n",
+			T.BulkReport{Read: 2, Added: 2, Done: true}, nil,
+			map[string]string{"a1": "http://old", "b2": "http://b", "c3": "http://c"}, 1},
+		{"fail on broken file", T.ConflictFail, "{\"hash\":\"b2\",\"link\":\"http://b\"}\n{\"hash\":\n",
+			T.BulkReport{Read: 1, Failed: 1}, T.ErrInvalidLink,
+			map[string]string{"b2": ""}, 1},
+		{"skip stops on broken file", T.ConflictSkip, "{\"hash\":\"b2\",\"link\":\"http://b\"}\n{\"hash\":\n",
+			T.BulkReport{Read: 1, Added: 1}, T.ErrInvalidLink,
+			map[string]string{"b2": "http://b"}, 1},
+		{"unknown policy", "merge", importFile,
+			T.BulkReport{}, T.ErrInvalidLink,
+			map[string]string{"b2": ""}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			bulk, db := testBulk(t)
+			progress := 0
+			report, err := bulk.Import(ctx, strings.NewReader(tt.file), "jsonl", tt.conflict, func(T.BulkReport) { progress++ })
+			if !errors.Is(err, tt.err) || ((err == nil) != (tt.err == nil)) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			report.Error = ""
+			if report != tt.want {
+				t.Fatalf("report %+v, want %+v", report, tt.want)
+			}
+			if progress == 0 {
+				t.Fatal("no final progress report")
+			}
+			for hash, link := range tt.links {
+				pair, err := db.LoadLinkPair(ctx, hash)
+				if (len(link) == 0) && !errors.Is(err, T.ErrNotFound) {
+					t.Fatalf("%s is saved: %+v", hash, pair)
+				}
+				if (len(link) != 0) && (pair.Link != link) {
+					t.Fatalf("%s links to %q, want %q (%v)", hash, pair.Link, link, err)
+				}
+			}
+			stats, _ := db.LoadLinkStats(ctx, "a1")
+			if stats.Clicks != tt.clicks {
+				t.Fatalf("a1 has %d clicks, want %d", stats.Clicks, tt.clicks)
+			}
+		})
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	from, _ := testBulk(t)
+	for _, format := range []string{"json", "jsonl", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			var file strings.Builder
+			if report, err := from.Export(ctx, &file, format, func(T.BulkReport) {}); (err != nil) || (report.Read != 1) {
+				t.Fatalf("export: %+v, %v", report, err)
+			}
+			to, db := testBulk(t)
+			db.DeleteLinkPair(ctx, "a1")
+			if report, err := to.Import(ctx, strings.NewReader(file.String()), format, T.ConflictFail, func(T.BulkReport) {}); (err != nil) || (report.Added != 1) {
+				t.Fatalf("import: %+v, %v", report, err)
+			}
+			if pair, err := db.LoadLinkPair(ctx, "a1"); (err != nil) || (pair.Link != "http://old") {
+				t.Fatalf("a1: %+v, %v", pair, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	F "shortlink2/internal/linkfile"
+	T "shortlink2/internal/types"
+)
+
+// seedFixtures saves links of SL_DB_FIXTURES at start, links already there are kept, so restarts do
+// not fail and do not overwrite edits; production leaves SL_DB_FIXTURES empty
+func (s *SvcShortLink2) seedFixtures(ctx context.Context) {
+	if len(s.fixtures) == 0 {
+		return
+	}
+	if err := s.loadFixtures(ctx, s.fixtures); err != nil {
+		s.log.LogError(fmt.Errorf("%s %s: %w", "SvcShortLink2.seedFixtures(): unable to load fixtures from", s.fixtures, err))
+	}
+}
+
+// loadFixtures checks links as saves do: canonical form, the policy and a hash reachable by routes,
+// rejected links are logged and skipped
+func (s *SvcShortLink2) loadFixtures(ctx context.Context, fname string) error {
+	format, err := F.FormatOf(fname)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	lr, err := F.NewReader(f, format)
+	if err != nil {
+		return err
+	}
+	hashRe := regexp.MustCompile("^" + s.HashPattern() + "$")
+	added, kept, rejected := 0, 0, 0
+	for {
+		pair, err := lr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.checkFixture(hashRe, &pair); err != nil {
+			s.log.LogWarn("fixture %s rejected: %s", pair.Hash, err.Error())
+			rejected++
+			continue
+		}
+		switch err := s.db.SaveLinkPair(ctx, pair); {
+		case errors.Is(err, T.ErrConflict):
+			kept++
+		case err != nil:
+			return err
+		default:
+			added++
+		}
+	}
+	s.log.LogInfo("fixtures loaded from %s: %d added, %d already in db, %d rejected", fname, added, kept, rejected)
+	return nil
+}
+
+func (s *SvcShortLink2) checkFixture(hashRe *regexp.Regexp, pair *T.DBMess) error {
+	if _, reserved := s.alias.reserved[pair.Hash]; reserved || !hashRe.MatchString(pair.Hash) {
+		return fmt.Errorf("%w: %s", T.ErrAliasInvalid, "hash does not match routes or is reserved")
+	}
+	link, err := s.checkLink(pair.Link)
+	if err != nil {
+		return err
+	}
+	pair.Link = link
+	return nil
+}
@@ -1,50 +1,269 @@
 package svc
 
 import (
-	"hash/crc32"
+	"context"
+	"errors"
+	"fmt"
 	T "shortlink2/internal/types"
-	"strconv"
+	"sync"
+	"time"
 )
 
 var _ T.ISvcShortLink2 = (*SvcShortLink2)(nil)
 
+// hashAttempts limits salted re-hashing when a short hash is already taken by another link
+const hashAttempts = 8
+
 type SvcShortLink2 struct {
-	db  T.IDB
-	log T.ILog
+	db       T.IDB
+	gen      T.IHashGen
+	pol      T.IPolicy
+	log      T.ILog
+	alias    *aliasPolicy
+	links    *linkPolicy
+	clicks   *clickBuffer
+	reapTime time.Duration
+	fixtures string // links file seeded at start
 }
 
-func NewSvcShortLink2(db T.IDB, log T.ILog) *SvcShortLink2 {
+func NewSvcShortLink2(db T.IDB, gen T.IHashGen, pol T.IPolicy, log T.ILog, cfg T.ICfg) *SvcShortLink2 {
+	reapTime, err := time.ParseDuration(cfg.GetVal(T.SL_REAP_PERIOD))
+	if (err != nil) || (reapTime < 0) {
+		log.LogError(fmt.Errorf("%s: %s=%s", "NewSvcShortLink2(): bad reap period, using 1m", T.SL_REAP_PERIOD, cfg.GetVal(T.SL_REAP_PERIOD)))
+		reapTime = time.Minute
+	}
 	return &SvcShortLink2{
-		db:  db,
-		log: log,
+		db:       db,
+		gen:      gen,
+		pol:      pol,
+		log:      log,
+		alias:    newAliasPolicy(cfg, log),
+		links:    newLinkPolicy(cfg, log),
+		clicks:   newClickBuffer(cfg, log),
+		reapTime: reapTime,
+		fixtures: cfg.GetVal(T.SL_DB_FIXTURES),
+	}
+}
+
+func (s *SvcShortLink2) GetLinkPair(ctx context.Context, hash string) (string, error) {
+	pair, err := s.GetLinkInfo(ctx, hash)
+	if err != nil {
+		return "", err
+	}
+	return pair.Link, nil
+}
+
+// GetLinkInfo re-checks the policy, so links to newly blocked hosts stop resolving and are not shown
+func (s *SvcShortLink2) GetLinkInfo(ctx context.Context, hash string) (T.DBMess, error) {
+	pair, err := s.liveLink(ctx, hash)
+	if err != nil {
+		return T.DBMess{}, err
+	}
+	if err := s.pol.Check(pair.Link); err != nil {
+		return T.DBMess{}, err
+	}
+	return pair, nil
+}
+
+// liveLink loads a link which is not expired, blocked ones too, so owners can point them elsewhere
+func (s *SvcShortLink2) liveLink(ctx context.Context, hash string) (T.DBMess, error) {
+	pair, err := s.db.LoadLinkPair(ctx, hash)
+	if err != nil {
+		return T.DBMess{}, err
+	}
+	if pair.IsExpired(time.Now()) {
+		return T.DBMess{}, T.ErrExpired
+	}
+	return pair, nil
+}
+
+// ListLinks gives links of the tenant, only admins can list other tenants; keys without tenant share
+// the empty tenant with anonymous links, so they get only links they own
+func (s *SvcShortLink2) ListLinks(ctx context.Context, tenant, after string, limit int) ([]T.DBMess, error) {
+	key, ok := T.APIKeyFrom(ctx)
+	if !ok || (!key.Admin && (key.Tenant != tenant)) {
+		return nil, fmt.Errorf("%w: %s", T.ErrForbidden, "links of another tenant")
+	}
+	owner := ""
+	if !key.Admin && (len(tenant) == 0) {
+		owner = key.ID
+	}
+	return s.db.ListLinks(ctx, tenant, owner, after, limit)
+}
+
+// SetLinkPair returns the existing hash for an already shortened link only with deterministic generators,
+// links are hashed in canonical form so equivalent ones get the same hash
+func (s *SvcShortLink2) SetLinkPair(ctx context.Context, link string, expire time.Time) (string, error) {
+	link, err := s.checkLink(link)
+	if err != nil {
+		return "", err
+	}
+	for salt := 0; salt < hashAttempts; salt++ {
+		hash := s.gen.Hash(link, salt)
+		if len(hash) == 0 {
+			continue
+		}
+		err := s.claimHash(ctx, s.newPair(ctx, hash, link, expire))
+		if !errors.Is(err, T.ErrConflict) {
+			return hash, err
+		}
+		s.log.Ctx(ctx).LogDebug("SvcShortLink2.SetLinkPair(): hash collision on %s, salt %d", hash, salt)
+	}
+	err = fmt.Errorf("%s: %s", "SvcShortLink2.SetLinkPair(): unable to find free hash", link)
+	s.log.Ctx(ctx).LogError(err)
+	return "", err
+}
+
+func (s *SvcShortLink2) SetLinkAlias(ctx context.Context, alias, link string, expire time.Time) (string, error) {
+	key, _ := T.APIKeyFrom(ctx)
+	alias, err := s.alias.withPrefix(alias, key.Tenant)
+	if err != nil {
+		return "", err
+	}
+	if err := s.alias.check(alias); err != nil {
+		return "", err
 	}
+	link, err = s.checkLink(link)
+	if err != nil {
+		return "", err
+	}
+	err = s.claimHash(ctx, s.newPair(ctx, alias, link, expire))
+	if errors.Is(err, T.ErrConflict) {
+		return "", T.ErrAliasTaken
+	}
+	if err != nil {
+		return "", err
+	}
+	return alias, nil
 }
 
-func (s *SvcShortLink2) GetLinkPair(hash string) string {
-	return s.db.LoadLinkPair(hash)
+func (s *SvcShortLink2) checkLink(link string) (string, error) {
+	link, err := s.links.canonical(link)
+	if err != nil {
+		return "", err
+	}
+	return link, s.pol.Check(link)
 }
 
-func (s *SvcShortLink2) SetLinkPair(link string) string {
-	hash := calcLinkShort(link)
-	if s.db.SaveLinkPair(hash, link) {
-		return hash
-	} else {
-		return ""
+// newPair records the key of request as the owner, anonymous links have no owner and tenant
+func (s *SvcShortLink2) newPair(ctx context.Context, hash, link string, expire time.Time) T.DBMess {
+	key, _ := T.APIKeyFrom(ctx)
+	return T.DBMess{Hash: hash, Link: link, Expire: expire, Owner: key.ID, Tenant: key.Tenant, Created: time.Now()}
+}
+
+// canModify lets only the owner or an admin change the link
+func canModify(ctx context.Context, pair T.DBMess) error {
+	key, ok := T.APIKeyFrom(ctx)
+	switch {
+	case ok && key.Admin:
+		return nil
+	case ok && (len(pair.Owner) != 0) && (key.ID == pair.Owner):
+		return nil
 	}
+	return fmt.Errorf("%w: %s is owned by another key", T.ErrForbidden, pair.Hash)
 }
 
-func (s *SvcShortLink2) DelLinkPair(hash string) bool {
-	return s.db.DeleteLinkPair(hash)
+// claimHash saves the pair if the hash is free or expired, a live hash of the same link, owner and expire
+// time is reused as is; another expire time is a conflict, so the caller tries the next hash
+func (s *SvcShortLink2) claimHash(ctx context.Context, pair T.DBMess) error {
+	old, err := s.db.LoadLinkPair(ctx, pair.Hash)
+	switch {
+	case errors.Is(err, T.ErrNotFound):
+	case err != nil:
+		return err
+	case !old.IsExpired(time.Now()):
+		if samePair(old, pair) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", T.ErrConflict, pair.Hash)
+	default:
+		if err := s.db.DeleteLinkPair(ctx, pair.Hash); (err != nil) && !errors.Is(err, T.ErrNotFound) {
+			return err
+		}
+	}
+	err = s.db.SaveLinkPair(ctx, pair)
+	if errors.Is(err, T.ErrConflict) {
+		if old, err := s.db.LoadLinkPair(ctx, pair.Hash); (err == nil) && samePair(old, pair) {
+			return nil // concurrent save of the same link
+		}
+	}
+	return err
+}
+
+// samePair compares expire times in seconds, as the db keeps them
+func samePair(old, pair T.DBMess) bool {
+	return (old.Link == pair.Link) && (old.Owner == pair.Owner) && (old.Expire.Unix() == pair.Expire.Unix())
 }
 
-func calcLinkShort(link string) string {
-	hashlen := 6
-	radixlen := 36
-	hash := crc32.ChecksumIEEE([]byte(link))
-	str := strconv.FormatUint(uint64(hash), radixlen)
-	if len(str) > hashlen {
-		idx := len(str) - hashlen
-		return str[idx:]
+// UpdLinkPair changes the target or the expire time of a live link, the hash stays the same
+func (s *SvcShortLink2) UpdLinkPair(ctx context.Context, hash, link string, expire *time.Time) error {
+	pair, err := s.liveLink(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if err := canModify(ctx, pair); err != nil {
+		return err
+	}
+	if len(link) != 0 {
+		if pair.Link, err = s.checkLink(link); err != nil {
+			return err
+		}
+	}
+	if expire != nil {
+		pair.Expire = *expire
+	}
+	return s.db.UpdateLinkPair(ctx, hash, pair.Link, pair.Expire)
+}
+
+// DelLinkPair deletes expired links too
+func (s *SvcShortLink2) DelLinkPair(ctx context.Context, hash string) error {
+	pair, err := s.db.LoadLinkPair(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if err := canModify(ctx, pair); err != nil {
+		return err
+	}
+	return s.db.DeleteLinkPair(ctx, hash)
+}
+
+// HashPattern matches both generated hashes and aliases
+func (s *SvcShortLink2) HashPattern() string {
+	return "(?:" + s.gen.Pattern() + "|" + s.alias.pattern() + ")"
+}
+
+func (s *SvcShortLink2) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
+}
+
+// Start seeds fixtures, then runs the clicks flusher and the reaper which purges expired links every reapTime
+func (s *SvcShortLink2) Start() func() {
+	var wg sync.WaitGroup
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	s.seedFixtures(ctx)
+	wg.Add(1)
+	go func() {
+		s.flushClicks(ctx)
+		wg.Done()
+	}()
+	if s.reapTime != 0 {
+		wg.Add(1)
+		go func() {
+			for {
+				select {
+				case <-time.After(s.reapTime):
+					if n, err := s.db.PurgeExpired(ctx, time.Now()); err == nil && n > 0 {
+						s.log.LogDebug("SvcShortLink2 reaper: %d expired links purged", n)
+					}
+				case <-ctx.Done():
+					wg.Done()
+					return
+				}
+			}
+		}()
+	}
+	return func() {
+		ctxCancel()
+		wg.Wait()
 	}
-	return str
 }
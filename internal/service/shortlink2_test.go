@@ -0,0 +1,95 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	D "shortlink2/internal/db"
+	L "shortlink2/internal/log"
+	T "shortlink2/internal/types"
+	"testing"
+	"time"
+)
+
+// saltHash collides all links on the same hash of each salt, as if crc32 matched them all
+type saltHash struct{}
+
+func (saltHash) Hash(link string, salt int) string { return fmt.Sprintf("hash%d", salt) }
+func (saltHash) Pattern() string                   { return "hash[0-9]" }
+
+type allowAll struct{}
+
+func (allowAll) Check(link string) error { return nil }
+func (allowAll) Start() func()           { return func() {} }
+
+func testSvc(t *testing.T) (*SvcShortLink2, *D.DBmock) {
+	cfg := cfgMap{T.SL_LOG_LEVEL: "NOLOG", T.SL_REAP_PERIOD: "0", T.SL_LINK_SCHEMES: "http,https", T.SL_LINK_MAXLEN: "2048", T.SL_LINK_FRAGMENT: "keep"}
+	log := L.NewLogFprintf(cfg, 0)
+	db := D.NewDBmock(cfg, log)
+	return NewSvcShortLink2(db, saltHash{}, allowAll{}, log, cfg), db
+}
+
+func TestSetLinkPairCollision(t *testing.T) {
+	ctx := context.Background()
+	s, db := testSvc(t)
+	first, err := s.SetLinkPair(ctx, "http://a.ru/", time.Time{})
+	if (err != nil) || (first != "hash0") {
+		t.Fatalf("first link: %s, %v", first, err)
+	}
+	second, err := s.SetLinkPair(ctx, "http://b.ru/", time.Time{})
+	if (err != nil) || (second != "hash1") {
+		t.Fatalf("colliding link: %s, %v, want the next salt", second, err)
+	}
+	for hash, link := range map[string]string{first: "http://a.ru/", second: "http://b.ru/"} {
+		if pair, _ := db.LoadLinkPair(ctx, hash); pair.Link != link {
+			t.Fatalf("%s links to %q, want %q", hash, pair.Link, link)
+		}
+	}
+	again, err := s.SetLinkPair(ctx, "http://B.ru:80/", time.Time{})
+	if (err != nil) || (again != second) {
+		t.Fatalf("same link again: %s, %v, want the existing %s", again, err, second)
+	}
+	expire := time.Now().Add(time.Hour)
+	other, err := s.SetLinkPair(ctx, "http://b.ru/", expire)
+	if (err != nil) || (other != "hash2") {
+		t.Fatalf("same link with another expire time: %s, %v, want a new hash", other, err)
+	}
+}
+
+func TestSetLinkPairExpired(t *testing.T) {
+	ctx := context.Background()
+	s, db := testSvc(t)
+	if err := db.SaveLinkPair(ctx, T.DBMess{Hash: "hash0", Link: "http://old.ru/", Expire: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveClicks(ctx, []T.DBClick{{Hash: "hash0", Time: time.Now(), Referer: "direct", Agent: "curl"}}); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := s.SetLinkPair(ctx, "http://new.ru/", time.Time{})
+	if (err != nil) || (hash != "hash0") {
+		t.Fatalf("expired hash is not reclaimed: %s, %v", hash, err)
+	}
+	if pair, _ := db.LoadLinkPair(ctx, "hash0"); (pair.Link != "http://new.ru/") || !pair.Expire.IsZero() {
+		t.Fatalf("reclaimed pair: %+v", pair)
+	}
+	if stats, _ := db.LoadLinkStats(ctx, "hash0"); stats.Clicks != 0 {
+		t.Fatalf("reclaimed hash has %d clicks of the expired link", stats.Clicks)
+	}
+}
+
+func TestSetLinkPairNoFreeHash(t *testing.T) {
+	ctx := context.Background()
+	s, db := testSvc(t)
+	for salt := 0; salt < hashAttempts; salt++ {
+		if err := db.SaveLinkPair(ctx, T.DBMess{Hash: fmt.Sprintf("hash%d", salt), Link: fmt.Sprintf("http://%d.ru/", salt)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash, err := s.SetLinkPair(ctx, "http://new.ru/", time.Time{})
+	if (err == nil) || (len(hash) != 0) {
+		t.Fatalf("all salts are taken: %s, %v", hash, err)
+	}
+	if _, err := db.LoadLinkPair(ctx, fmt.Sprintf("hash%d", hashAttempts)); !errors.Is(err, T.ErrNotFound) {
+		t.Fatalf("salt beyond %d attempts is used: %v", hashAttempts, err)
+	}
+}
@@ -0,0 +1,146 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	T "shortlink2/internal/types"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// clickBuffer keeps redirect events in memory and writes them to db in batches,
+// so the redirect path never waits for db
+type clickBuffer struct {
+	events    chan T.DBClick
+	batch     int
+	flushTime time.Duration
+	dropped   atomic.Int64
+}
+
+func newClickBuffer(cfg T.ICfg, log T.ILog) *clickBuffer {
+	size, err1 := strconv.Atoi(cfg.GetVal(T.SL_STATS_BUFFER))
+	if (err1 != nil) || (size <= 0) {
+		log.LogError(fmt.Errorf("%s: %s=%s", "newClickBuffer(): bad buffer size, using 4096", T.SL_STATS_BUFFER, cfg.GetVal(T.SL_STATS_BUFFER)))
+		size = 4096
+	}
+	batch, err2 := strconv.Atoi(cfg.GetVal(T.SL_STATS_BATCH))
+	if (err2 != nil) || (batch <= 0) {
+		log.LogError(fmt.Errorf("%s: %s=%s", "newClickBuffer(): bad batch size, using 256", T.SL_STATS_BATCH, cfg.GetVal(T.SL_STATS_BATCH)))
+		batch = 256
+	}
+	flushTime, err3 := time.ParseDuration(cfg.GetVal(T.SL_STATS_FLUSH))
+	if (err3 != nil) || (flushTime <= 0) {
+		log.LogError(fmt.Errorf("%s: %s=%s", "newClickBuffer(): bad flush period, using 5s", T.SL_STATS_FLUSH, cfg.GetVal(T.SL_STATS_FLUSH)))
+		flushTime = 5 * time.Second
+	}
+	return &clickBuffer{
+		events:    make(chan T.DBClick, size),
+		batch:     batch,
+		flushTime: flushTime,
+	}
+}
+
+func (s *SvcShortLink2) Click(hash, referer, agent string) {
+	click := T.DBClick{
+		Hash:    hash,
+		Time:    time.Now(),
+		Referer: refererHost(referer),
+		Agent:   agentFamily(agent),
+	}
+	select {
+	case s.clicks.events <- click:
+	default:
+		s.clicks.dropped.Add(1)
+	}
+}
+
+func (s *SvcShortLink2) GetLinkStats(ctx context.Context, hash string) (T.LinkStats, error) {
+	stats, err := s.db.LoadLinkStats(ctx, hash)
+	if err != nil {
+		return T.LinkStats{}, err
+	}
+	if stats.Clicks == 0 {
+		if _, err := s.db.LoadLinkPair(ctx, hash); err != nil {
+			return T.LinkStats{}, err
+		}
+	}
+	return stats, nil
+}
+
+// flushClicks writes buffered clicks until ctx is done, then drains the buffer once more
+func (s *SvcShortLink2) flushClicks(ctx context.Context) {
+	batch := make([]T.DBClick, 0, s.clicks.batch)
+	saveCtx := context.WithoutCancel(ctx) // the last flush happens after ctx is done
+	flush := func() {
+		if len(batch) != 0 {
+			if err := s.db.SaveClicks(saveCtx, batch); err != nil {
+				s.log.LogWarn("SvcShortLink2.flushClicks(): %d clicks lost", len(batch))
+			}
+			batch = batch[:0]
+		}
+		if n := s.clicks.dropped.Swap(0); n > 0 {
+			s.log.LogWarn("SvcShortLink2.flushClicks(): %d clicks dropped, buffer is full", n)
+		}
+	}
+	tick := time.NewTicker(s.clicks.flushTime)
+	defer tick.Stop()
+	for {
+		select {
+		case click := <-s.clicks.events:
+			batch = append(batch, click)
+			if len(batch) >= s.clicks.batch {
+				flush()
+			}
+		case <-tick.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case click := <-s.clicks.events:
+					batch = append(batch, click)
+					if len(batch) >= s.clicks.batch {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func refererHost(referer string) string {
+	if u, err := url.Parse(referer); (err == nil) && (len(u.Hostname()) != 0) {
+		return strings.ToLower(u.Hostname())
+	}
+	return "direct"
+}
+
+// agentFamily is a rough user agent classification, order matters because browsers mimic each other
+func agentFamily(agent string) string {
+	ua := strings.ToLower(agent)
+	switch {
+	case len(ua) == 0:
+		return "unknown"
+	case strings.Contains(ua, "bot") || strings.Contains(ua, "spider") || strings.Contains(ua, "crawl"):
+		return "bot"
+	case strings.HasPrefix(ua, "curl/") || strings.HasPrefix(ua, "wget/") || strings.Contains(ua, "httpclient") || strings.HasPrefix(ua, "go-http-client"):
+		return "tool"
+	case strings.Contains(ua, "edg/"):
+		return "edge"
+	case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+		return "opera"
+	case strings.Contains(ua, "firefox/"):
+		return "firefox"
+	case strings.Contains(ua, "chrome/") || strings.Contains(ua, "chromium/"):
+		return "chrome"
+	case strings.Contains(ua, "safari/"):
+		return "safari"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,121 @@
+package svc
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	T "shortlink2/internal/types"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// defaultPorts are stripped from canonical links, so http://lib.ru:80 and http://lib.ru share a hash
+var defaultPorts = map[string]string{"http": "80", "https": "443", "ftp": "21", "ws": "80", "wss": "443"}
+
+type linkPolicy struct {
+	schemes   map[string]struct{}
+	maxlen    int
+	fragments bool
+}
+
+func newLinkPolicy(cfg T.ICfg, log T.ILog) *linkPolicy {
+	lp := &linkPolicy{
+		schemes:   map[string]struct{}{},
+		maxlen:    2048,
+		fragments: true,
+	}
+	for _, scheme := range strings.Split(cfg.GetVal(T.SL_LINK_SCHEMES), ",") {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); len(scheme) != 0 {
+			lp.schemes[scheme] = struct{}{}
+		}
+	}
+	if len(lp.schemes) == 0 {
+		log.LogError(fmt.Errorf("%s: %s=%s", "newLinkPolicy(): no link schemes, using http,https", T.SL_LINK_SCHEMES, cfg.GetVal(T.SL_LINK_SCHEMES)))
+		lp.schemes = map[string]struct{}{"http": {}, "https": {}}
+	}
+	if maxlen, err := strconv.Atoi(cfg.GetVal(T.SL_LINK_MAXLEN)); (err == nil) && (maxlen > 0) {
+		lp.maxlen = maxlen
+	} else {
+		log.LogError(fmt.Errorf("%s: %s=%s", "newLinkPolicy(): bad link max length, using 2048", T.SL_LINK_MAXLEN, cfg.GetVal(T.SL_LINK_MAXLEN)))
+	}
+	switch cfg.GetVal(T.SL_LINK_FRAGMENT) {
+	case "keep":
+	case "strip":
+		lp.fragments = false
+	default:
+		log.LogError(fmt.Errorf("%s: %s=%s", "newLinkPolicy(): bad fragment policy, using keep", T.SL_LINK_FRAGMENT, cfg.GetVal(T.SL_LINK_FRAGMENT)))
+	}
+	return lp
+}
+
+// canonical validates the link and returns its canonical form: lowercase scheme and host,
+// punycode host, no default port and no fragment if the policy strips them
+func (lp *linkPolicy) canonical(link string) (string, error) {
+	link = strings.TrimSpace(link)
+	switch {
+	case len(link) == 0:
+		return "", fmt.Errorf("%w: %s", T.ErrInvalidLink, "empty link")
+	case len(link) > lp.maxlen:
+		return "", fmt.Errorf("%w: longer than %d bytes", T.ErrInvalidLink, lp.maxlen)
+	}
+	for _, r := range link {
+		if (r <= ' ') || (r == 0x7f) || (r == utf8.RuneError) {
+			return "", fmt.Errorf("%w: %s", T.ErrInvalidLink, "whitespace, control or invalid chars")
+		}
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", T.ErrInvalidLink, err.Error())
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if _, ok := lp.schemes[scheme]; !ok {
+		return "", fmt.Errorf("%w: scheme %q is not allowed", T.ErrInvalidLink, u.Scheme)
+	}
+	if len(u.Opaque) != 0 || len(u.Hostname()) == 0 {
+		return "", fmt.Errorf("%w: %s", T.ErrInvalidLink, "absolute link with host is required")
+	}
+	if u.User != nil { // http://trusted.com@evil.com
+		return "", fmt.Errorf("%w: %s", T.ErrInvalidLink, "user info is not allowed")
+	}
+	host, err := canonicalHost(u.Hostname())
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", T.ErrInvalidLink, err.Error())
+	}
+	port := u.Port()
+	if port == defaultPorts[scheme] {
+		port = ""
+	}
+	if len(port) != 0 {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	u.Scheme, u.Host = scheme, host
+	if !lp.fragments {
+		u.Fragment, u.RawFragment = "", ""
+	}
+	res := u.String()
+	if len(res) > lp.maxlen {
+		return "", fmt.Errorf("%w: longer than %d bytes", T.ErrInvalidLink, lp.maxlen)
+	}
+	return res, nil
+}
+
+// canonicalHost converts IDN hosts to punycode with the IDNA lookup mapping browsers use, so
+// equivalent spellings share a hash; IP literals are kept as is
+func canonicalHost(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return "", err
+	}
+	if len(ascii) == 0 {
+		return "", fmt.Errorf("%s: %s", "empty host", host)
+	}
+	return ascii, nil
+}
@@ -0,0 +1,37 @@
+package types
+
+import (
+	"context"
+	"time"
+)
+
+type ISvcAuth interface {
+	Authenticate(ctx context.Context, token string) (APIKey, error) // ErrUnauthorized if the key is unknown or revoked
+	CreateKey(ctx context.Context, name, tenant string, admin bool) (string, APIKey, error)
+	RevokeKey(ctx context.Context, id string) error
+	ListKeys(ctx context.Context) ([]APIKey, error)
+	AnonSave() bool
+}
+
+// APIKey is stored without the token itself, only sha256 of it is kept
+type APIKey struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Hash    string    `json:"-"`
+	Tenant  string    `json:"tenant"`
+	Admin   bool      `json:"admin"`
+	Created time.Time `json:"created"`
+	Revoked time.Time `json:"revoked"` // zero value means the key is active
+}
+
+type apiKeyCtx struct{}
+
+func WithAPIKey(ctx context.Context, key APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyCtx{}, key)
+}
+
+// APIKeyFrom gives the key of authenticated request, false for anonymous ones
+func APIKeyFrom(ctx context.Context) (APIKey, bool) {
+	key, ok := ctx.Value(apiKeyCtx{}).(APIKey)
+	return key, ok
+}
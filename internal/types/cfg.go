@@ -3,11 +3,49 @@ package types
 type ICfg interface {
 	GetVal(string) string
 	Parse() ICfg
+	Validate() error // nil if all known values are well formed
 }
 
 const (
-	SL_APP_NAME  = "SL_APP_NAME"
-	SL_LOG_LEVEL = "SL_LOG_LEVEL"
-	SL_HTTP_IP   = "SL_HTTP_IP"
-	SL_HTTP_PORT = "SL_HTTP_PORT"
+	SL_APP_NAME            = "SL_APP_NAME"
+	SL_LOG_LEVEL           = "SL_LOG_LEVEL"
+	SL_HTTP_IP             = "SL_HTTP_IP"
+	SL_HTTP_PORT           = "SL_HTTP_PORT"
+	SL_HTTP_DRAIN          = "SL_HTTP_DRAIN"
+	SL_HASH_GEN            = "SL_HASH_GEN"
+	SL_HASH_LEN            = "SL_HASH_LEN"
+	SL_HASH_ALPHABET       = "SL_HASH_ALPHABET"
+	SL_HASH_SALT           = "SL_HASH_SALT"
+	SL_ALIAS_CHARSET       = "SL_ALIAS_CHARSET"
+	SL_ALIAS_MINLEN        = "SL_ALIAS_MINLEN"
+	SL_ALIAS_MAXLEN        = "SL_ALIAS_MAXLEN"
+	SL_ALIAS_RESERVED      = "SL_ALIAS_RESERVED"
+	SL_REAP_PERIOD         = "SL_REAP_PERIOD"
+	SL_STATS_BUFFER        = "SL_STATS_BUFFER"
+	SL_STATS_BATCH         = "SL_STATS_BATCH"
+	SL_STATS_FLUSH         = "SL_STATS_FLUSH"
+	SL_DB_TIMEOUT          = "SL_DB_TIMEOUT"
+	SL_DB_FIXTURES         = "SL_DB_FIXTURES"
+	SL_DB_PATH             = "SL_DB_PATH"
+	SL_DB_JOURNAL          = "SL_DB_JOURNAL"
+	SL_DB_SYNC             = "SL_DB_SYNC"
+	SL_DB_BUSY_TIMEOUT     = "SL_DB_BUSY_TIMEOUT"
+	SL_DB_CACHE_SIZE       = "SL_DB_CACHE_SIZE"
+	SL_BACKUP_DIR          = "SL_BACKUP_DIR"
+	SL_BACKUP_PERIOD       = "SL_BACKUP_PERIOD"
+	SL_BACKUP_KEEP         = "SL_BACKUP_KEEP"
+	SL_LINK_SCHEMES        = "SL_LINK_SCHEMES"
+	SL_LINK_MAXLEN         = "SL_LINK_MAXLEN"
+	SL_LINK_FRAGMENT       = "SL_LINK_FRAGMENT"
+	SL_POLICY_FILE         = "SL_POLICY_FILE"
+	SL_POLICY_RELOAD       = "SL_POLICY_RELOAD"
+	SL_POLICY_PRIVATE      = "SL_POLICY_PRIVATE"
+	SL_AUTH_ANONSAVE       = "SL_AUTH_ANONSAVE"
+	SL_TENANT_PREFIX       = "SL_TENANT_PREFIX"
+	SL_RATE_REDIRECT       = "SL_RATE_REDIRECT"
+	SL_RATE_REDIRECT_BURST = "SL_RATE_REDIRECT_BURST"
+	SL_RATE_SAVE           = "SL_RATE_SAVE"
+	SL_RATE_SAVE_BURST     = "SL_RATE_SAVE_BURST"
+	SL_RATE_TRUSTED        = "SL_RATE_TRUSTED"
+	SL_METRICS             = "SL_METRICS"
 )
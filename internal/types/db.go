@@ -1,13 +1,86 @@
 package types
 
+import (
+	"context"
+	"time"
+)
+
 type IDB interface {
-	SaveLinkPair(hash, link string) bool
-	LoadLinkPair(hash string) string
-	DeleteLinkPair(hash string) bool
-	ConnectDB() func(e error)
+	SaveLinkPair(ctx context.Context, pair DBMess) error                                     // ErrConflict if the hash is already taken
+	LoadLinkPair(ctx context.Context, hash string) (DBMess, error)                           // ErrNotFound if there is no such hash
+	UpdateLinkPair(ctx context.Context, hash, link string, expire time.Time) error           // ErrNotFound if there is no such hash
+	DeleteLinkPair(ctx context.Context, hash string) error                                   // ErrNotFound if there is no such hash
+	ListLinks(ctx context.Context, tenant, owner, after string, limit int) ([]DBMess, error) // ordered by hash, after the cursor, any owner if empty
+	ScanLinks(ctx context.Context, after string, limit int) ([]DBMess, error)                // links of all tenants, the same order, for export
+	ReplaceLinkPair(ctx context.Context, pair DBMess) error                                  // saves the pair, overwriting the one with the same hash and its clicks
+	SaveLinkPairs(ctx context.Context, next func() (DBMess, error)) (int, error)             // saves pairs of next until io.EOF all or none, ErrConflict if a hash is taken
+	PurgeExpired(ctx context.Context, now time.Time) (int64, error)
+	SaveClicks(ctx context.Context, clicks []DBClick) error
+	LoadLinkStats(ctx context.Context, hash string) (LinkStats, error)
+	SaveAPIKey(ctx context.Context, key APIKey) error                 // ErrConflict if the id or hash is already taken
+	LoadAPIKey(ctx context.Context, hash string) (APIKey, error)      // ErrNotFound if there is no such key hash
+	RevokeAPIKey(ctx context.Context, id string, now time.Time) error // ErrNotFound if there is no such active key
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	ReserveCounter(ctx context.Context, name string, n uint64) (uint64, error) // ICounterStore of hash generators
+	Ping(ctx context.Context) error                                            // ErrUnavailable if the db does not answer, for readiness probes
+	ConnectDB() (func(e error), error)                                         // the error is a db the app must not start on, whatever the log level
+}
+
+// IDBMigrator is implemented by dbs with versioned schema, ConnectDB applies pending migrations
+// and refuses to start if the db is newer than the binary
+type IDBMigrator interface {
+	Migrations(ctx context.Context) ([]Migration, error)           // all migrations of the binary, Applied is zero for pending ones
+	Migrate(ctx context.Context, dryRun bool) ([]Migration, error) // applies pending migrations and returns them, dryRun only lists them
+	ManualMigrate()                                                // the next ConnectDB leaves the schema to CLI commands
+}
+
+// IDBBackup is implemented by dbs with online snapshots, Start runs scheduled backups
+type IDBBackup interface {
+	Backup(ctx context.Context) (Snapshot, error)   // consistent copy made while serving, snapshots beyond retention are removed
+	Snapshots() ([]Snapshot, error)                 // newest first
+	Restore(ctx context.Context, name string) error // validates the snapshot and swaps it in, for CLI, fails while a server has the db open
+	Start() func()
+}
+
+type Snapshot struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+	Applied time.Time
 }
 
 type DBMess struct {
-	Hash string
-	Link string
+	Hash    string
+	Link    string
+	Expire  time.Time // zero value means the link never expires
+	Owner   string    // API key id of the creator, empty for anonymous links
+	Tenant  string    // tenant of the creator key
+	Created time.Time // zero for links made before creation time was kept
+}
+
+type DBClick struct {
+	Hash    string
+	Time    time.Time
+	Referer string // referer host or "direct"
+	Agent   string // user agent family
+}
+
+type LinkStats struct {
+	Hash     string           `json:"hash"`
+	Clicks   int64            `json:"clicks"`
+	First    *time.Time       `json:"first,omitempty"`
+	Last     *time.Time       `json:"last,omitempty"`
+	Referers map[string]int64 `json:"referers"`
+	Agents   map[string]int64 `json:"agents"`
+	Days     map[string]int64 `json:"days"` // YYYY-MM-DD in UTC
+}
+
+func (m DBMess) IsExpired(now time.Time) bool {
+	return !m.Expire.IsZero() && !now.Before(m.Expire)
 }
@@ -0,0 +1,21 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors shared by db, service and http layers, check them with errors.Is()
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrInvalidLink  = errors.New("invalid link")
+	ErrUnavailable  = errors.New("storage unavailable")
+	ErrForbidden    = errors.New("forbidden")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrRateLimited  = errors.New("rate limit exceeded")
+
+	ErrExpired      = fmt.Errorf("%w: link has expired", ErrNotFound)
+	ErrAliasInvalid = errors.New("alias is invalid")
+	ErrAliasTaken   = fmt.Errorf("%w: alias is already taken", ErrConflict)
+)
@@ -0,0 +1,13 @@
+package types
+
+import "context"
+
+type IHashGen interface {
+	Hash(link string, salt int) string
+	Pattern() string
+}
+
+// ICounterStore keeps counters of hash generators across restarts
+type ICounterStore interface {
+	ReserveCounter(ctx context.Context, name string, n uint64) (uint64, error) // first of n values never given before
+}
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 type IHTTPServer interface {
 	Run() func(e error)
 }
@@ -8,4 +10,77 @@ type HTTPMess struct {
 	Method string `json:"M"`
 	Hash   string `json:"H"`
 	Link   string `json:"L"`
+	Alias  string `json:"A,omitempty"`
+	TTL    string `json:"T,omitempty"` // duration from now, e.g. "24h"
+	Expire string `json:"X,omitempty"` // RFC3339 timestamp
+	Error  string `json:"E,omitempty"` // error message of responses, M holds the status code
+}
+
+// APILinkReq is the body of POST and PATCH /api/v1/links requests
+type APILinkReq struct {
+	Link   string `json:"link"`
+	Alias  string `json:"alias,omitempty"`
+	TTL    string `json:"ttl,omitempty"`    // duration from now, e.g. "24h"
+	Expire string `json:"expire,omitempty"` // RFC3339 timestamp
+}
+
+type APILink struct {
+	Hash    string     `json:"hash"`
+	Link    string     `json:"link"`
+	Expire  *time.Time `json:"expire,omitempty"`
+	Owner   string     `json:"owner,omitempty"`
+	Tenant  string     `json:"tenant,omitempty"`
+	Created *time.Time `json:"created,omitempty"`
+}
+
+// NewAPILink converts the db pair to the shape of API responses and link files, times go in UTC
+func NewAPILink(pair DBMess) APILink {
+	link := APILink{Hash: pair.Hash, Link: pair.Link, Owner: pair.Owner, Tenant: pair.Tenant}
+	if !pair.Expire.IsZero() {
+		expire := pair.Expire.UTC()
+		link.Expire = &expire
+	}
+	if !pair.Created.IsZero() {
+		created := pair.Created.UTC()
+		link.Created = &created
+	}
+	return link
+}
+
+func (l APILink) DBMess() DBMess {
+	pair := DBMess{Hash: l.Hash, Link: l.Link, Owner: l.Owner, Tenant: l.Tenant}
+	if l.Expire != nil {
+		pair.Expire = *l.Expire
+	}
+	if l.Created != nil {
+		pair.Created = *l.Created
+	}
+	return pair
+}
+
+// APILinkList is a page of GET /api/v1/links, pass Next as cursor to get the next page
+type APILinkList struct {
+	Links []APILink `json:"links"`
+	Next  string    `json:"next,omitempty"`
+}
+
+// Health is the body of /healthz and /readyz, Status is "ok" or "fail"
+type Health struct {
+	Status     string                     `json:"status"`
+	Components map[string]HealthComponent `json:"components,omitempty"`
+}
+
+type HealthComponent struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// APIError is the error envelope of /api/v1 responses
+type APIError struct {
+	Error APIErrorBody `json:"error"`
+}
+
+type APIErrorBody struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
 }
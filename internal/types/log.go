@@ -1,12 +1,22 @@
 package types
 
+import "context"
+
 type ILog interface {
 	Start() func()
+	Ctx(ctx context.Context) ILog // logger adding the request id of ctx to messages
 	LogTrace(format string, v ...any)
 	LogDebug(format string, v ...any)
 	LogInfo(format string, v ...any)
+	LogInfoFields(mess string, fields ...LogField) // fields go as own JSON keys after the message
 	LogWarn(format string, v ...any)
 	LogError(err error)
 	LogFatal(err error)
 	LogPanic(err error)
 }
+
+// LogField is a key of structured log lines, keys must not clash with the common ones (T, L, H, S, R, M)
+type LogField struct {
+	Key string
+	Val any // JSON encoded
+}
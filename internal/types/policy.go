@@ -0,0 +1,7 @@
+package types
+
+// IPolicy decides if a canonical link may be shortened and redirected to
+type IPolicy interface {
+	Check(link string) error
+	Start() func()
+}
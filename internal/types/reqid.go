@@ -0,0 +1,15 @@
+package types
+
+import "context"
+
+type requestIDCtx struct{}
+
+// WithRequestID marks the request context with X-Request-ID, loggers take it by ILog.Ctx()
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDCtx{}, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtx{}).(string)
+	return id
+}
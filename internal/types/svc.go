@@ -1,7 +1,46 @@
 package types
 
+import (
+	"context"
+	"io"
+	"time"
+)
+
 type ISvcShortLink2 interface {
-	GetLinkPair(hash string) string
-	SetLinkPair(link string) string
-	DelLinkPair(hash string) bool
+	GetLinkPair(ctx context.Context, hash string) (string, error)
+	GetLinkInfo(ctx context.Context, hash string) (DBMess, error)
+	ListLinks(ctx context.Context, tenant, after string, limit int) ([]DBMess, error)
+	SetLinkPair(ctx context.Context, link string, expire time.Time) (string, error)
+	SetLinkAlias(ctx context.Context, alias, link string, expire time.Time) (string, error)
+	UpdLinkPair(ctx context.Context, hash, link string, expire *time.Time) error // empty link and nil expire keep the current ones
+	DelLinkPair(ctx context.Context, hash string) error
+	Click(hash, referer, agent string)
+	GetLinkStats(ctx context.Context, hash string) (LinkStats, error)
+	HashPattern() string
+	Ping(ctx context.Context) error // ErrUnavailable if the db does not answer
+	Start() func()
+}
+
+// ISvcBulk moves links between environments as link files (.json, .jsonl, .csv), over any IDB
+type ISvcBulk interface {
+	Export(ctx context.Context, w io.Writer, format string, progress func(BulkReport)) (BulkReport, error)
+	Import(ctx context.Context, r io.Reader, format, conflict string, progress func(BulkReport)) (BulkReport, error)
+}
+
+// conflict policies of Import for hashes which are already in the db
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictFail      = "fail"
+)
+
+// BulkReport counts links of Export and Import, it is also sent as progress every few thousand links
+type BulkReport struct {
+	Read        int    `json:"read"`            // links read from the db on export or from the file on import
+	Added       int    `json:"added"`           // new links saved by import
+	Overwritten int    `json:"overwritten"`     // links overwritten by import, their clicks are reset
+	Skipped     int    `json:"skipped"`         // links kept as they were in the db by import
+	Failed      int    `json:"failed"`          // links read but not saved as the import stopped, all of them for the fail policy
+	Error       string `json:"error,omitempty"` // why the import stopped, links before it are saved unless the policy is fail
+	Done        bool   `json:"done"`
 }